@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// acceptSlots bounds how many accepts negotiate (SetRemoteDescription and
+// ICE connect) at once under --max-concurrent-accepts; a nil channel is
+// unlimited. A reload that changes the limit swaps in a new channel, and
+// accepts holding a slot give it back to the one they took it from.
+var acceptSlots atomic.Pointer[chan struct{}]
+
+// maxAcceptHold frees the slot of an accept whose call neither connects
+// nor ends, so stuck calls cannot starve the rest
+const maxAcceptHold = 10 * time.Second
+
+// acceptRetryAfter is the Retry-After sent with an accept that found no slot
+const acceptRetryAfter = time.Second
+
+func setAcceptSlots(n int) {
+	var slots chan struct{}
+	if n > 0 {
+		slots = make(chan struct{}, n)
+	}
+	acceptSlots.Store(&slots)
+}
+
+// acquireAcceptSlot waits up to --accept-queue-timeout for room to
+// negotiate an accept, then holds the slot until the call's ICE connects
+// or it ends. A call that ends while queued needs no slot.
+func acquireAcceptSlot(callID string, details *CallIDDetails) *requestError {
+	var slots chan struct{}
+	if p := acceptSlots.Load(); p != nil {
+		slots = *p
+	}
+	if slots == nil {
+		return nil
+	}
+
+	timer := time.NewTimer(currentConfig().AcceptQueueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+	case <-details.ctx.Done():
+		return nil
+	case <-timer.C:
+		metrics.ShedRequests.Add(1)
+		err := callError(fiber.StatusServiceUnavailable, "Overloaded: too many accepts in progress, retry later", callID)
+		err.retryAfter = acceptRetryAfter
+		return err
+	}
+
+	details.goTracked("accept_slot", func() {
+		hold := time.NewTimer(maxAcceptHold)
+		defer hold.Stop()
+		select {
+		case <-details.connected:
+		case <-details.ctx.Done():
+		case <-hold.C:
+		}
+		<-slots
+	})
+	return nil
+}
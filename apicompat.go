@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiScheme maps our JSON field names to the names a SUT version uses on
+// the wire. Fields it does not mention keep their name.
+type apiScheme map[string]string
+
+// apiSchemes are the field-naming schemes selectable with --api-compat
+var apiSchemes = map[string]apiScheme{
+	"current": {},
+	"legacy": {
+		"sdp_type":          "type",
+		"messaging_product": "product",
+	},
+}
+
+func validateAPICompat(name string) error {
+	if _, ok := apiSchemes[name]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(apiSchemes))
+	for n := range apiSchemes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("invalid api compat scheme %q (want one of %s)", name, strings.Join(names, ", "))
+}
+
+func currentAPIScheme() apiScheme {
+	return apiSchemes[config.APICompat]
+}
+
+// field returns the wire name of one of our field names
+func (s apiScheme) field(name string) string {
+	if wire, ok := s[name]; ok {
+		return wire
+	}
+	return name
+}
+
+// encode marshals v, renaming fields at every level of nesting
+func (s apiScheme) encode(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(s) == 0 {
+		return data, err
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameFields(generic, s))
+}
+
+// decode unmarshals a request body into v, mapping top-level wire names
+// back to ours. Nested fields are left alone because their names are
+// ambiguous (an answer's session.type is not an sdp_type); code reading
+// them looks the wire name up with field.
+func (s apiScheme) decode(body []byte, v any) error {
+	if len(s) == 0 {
+		return json.Unmarshal(body, v)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return err
+	}
+	for name, wire := range s {
+		if raw, ok := object[wire]; ok {
+			if _, taken := object[name]; !taken {
+				object[name] = raw
+				delete(object, wire)
+			}
+		}
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func renameFields(value any, names map[string]string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		renamed := make(map[string]any, len(v))
+		for key, child := range v {
+			wire := key
+			if to, ok := names[key]; ok {
+				// Never clobber a field that already uses the wire name
+				if _, taken := v[to]; !taken {
+					wire = to
+				}
+			}
+			renamed[wire] = renameFields(child, names)
+		}
+		return renamed
+	case []any:
+		for i, child := range v {
+			v[i] = renameFields(child, names)
+		}
+	}
+	return value
+}
+
+// parseRequest parses a request body using the --api-compat field names
+func parseRequest(c *fiber.Ctx, v any) error {
+	scheme := currentAPIScheme()
+	if len(scheme) == 0 {
+		return parseBody(c, v)
+	}
+	body := c.Body()
+	if isMsgpackBody(c) {
+		var err error
+		if body, err = msgpackToJSON(body); err != nil {
+			return err
+		}
+	}
+	return scheme.decode(body, v)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+// registerSinks subscribes the event sinks exactly once, however many
+// apps are built in a process
+var registerSinks = sync.OnceValue(func() error {
+	registerEventSinks()
+	return registerStatsDSink()
+})
+
+// NewApp makes cfg the running configuration, registers the event sinks,
+// sets up ICE servers and the WebRTC APIs, and returns a Fiber app with
+// every route and middleware of the load API. main calls it once cfg is
+// validated and media is loaded; tests can build the same app from their
+// own Config and drive it with app.Test.
+func NewApp(cfg Config) (*fiber.App, error) {
+	config = cfg
+	liveConfig.Store(&cfg)
+
+	if err := registerSinks(); err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	preferredAddrs = nil
+	if cfg.PreferInterface != "" {
+		addrs, err := interfaceAddrs(cfg.PreferInterface)
+		if err != nil {
+			return nil, fmt.Errorf("prefer-interface: %w", err)
+		}
+		preferredAddrs = addrs
+	}
+	if err := loadICEServers(cfg); err != nil {
+		return nil, fmt.Errorf("ice servers: %w", err)
+	}
+	if err := setupWebRTC(); err != nil {
+		return nil, fmt.Errorf("webrtc: %w", err)
+	}
+
+	toPool = newNumberPool(cfg.ToPool)
+	fromPool = newNumberPool(cfg.FromPool)
+	answerAudioPool = newNumberPool(cfg.AnswerAudioPool)
+	setAcceptSlots(cfg.MaxConcurrentAccepts)
+
+	app := fiber.New()
+
+	app.Use(logger.New(logger.Config{
+		Format: "${time} | " + cfg.NodeID + " | ${status} | ${method} | ${path} | ${latency}\n",
+	}))
+
+	app.Post("/load/offer", processOffer)
+	app.Post("/load/offer/bulk", processBulkOffer)
+
+	app.Post("/load/calls", processAnswer)
+	app.Get("/load/calls", listCalls)
+
+	app.Post("/load/action", processAction)
+
+	app.Post("/load/reload", reloadConfig)
+
+	app.Post("/load/diag/gather", diagGather)
+
+	app.Get("/stats", getStats)
+	app.Get("/stats/:call_id", getCallStats)
+	app.Get("/metrics", getMetrics)
+	app.Get("/report", getReport)
+
+	app.Get("/healthz", getHealthz)
+	app.Get("/readyz", getReadyz)
+
+	return app, nil
+}
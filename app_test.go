@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultConfig is the flag defaults every test app starts from
+var defaultConfig Config
+
+func TestMain(m *testing.M) {
+	registerFlags()
+	flag.Parse()
+	config.AllowedProducts = stringList{"whatsapp"}
+	config.AllowedObjects = stringList{"whatsapp_business_account"}
+	config.HostOnly = true
+	config.LeakCheckDelay = 0
+	defaultConfig = config
+	events.Subscribe(liveCalls.track)
+	os.Exit(m.Run())
+}
+
+// liveCalls holds the calls created but not yet through finishTeardown,
+// so a test can wait for every teardown before the next one swaps config
+var liveCalls = &callTracker{calls: map[string]bool{}, ended: map[string]int{}, outcomes: map[string]string{}}
+
+type callTracker struct {
+	mu       sync.Mutex
+	calls    map[string]bool
+	ended    map[string]int
+	outcomes map[string]string
+}
+
+func (c *callTracker) track(event LifecycleEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch event.Type {
+	case EventCreated:
+		c.calls[event.CallID] = true
+	case EventTerminated:
+		delete(c.calls, event.CallID)
+		c.ended[event.CallID]++
+		c.outcomes[event.CallID] = event.Record.Outcome
+	}
+}
+
+// outcome is the CDR outcome of callID, once it is torn down
+func (c *callTracker) outcome(callID string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.outcomes[callID]
+}
+
+// terminations is how many times callID was reported torn down, which is
+// also how many CDRs it got
+func (c *callTracker) terminations(callID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ended[callID]
+}
+
+func (c *callTracker) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.calls)
+}
+
+// settle polls cond until it holds or timeout passes
+func settle(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// newTestApp builds an app from the defaults with edit applied, and tears
+// down whatever calls a test leaves behind
+func newTestApp(t testing.TB, edit func(*Config)) *fiber.App {
+	t.Helper()
+	cfg := defaultConfig
+	if edit != nil {
+		edit(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() { teardownAllCalls(t) })
+	return app
+}
+
+func teardownAllCalls(t testing.TB) {
+	ActionChannels.Range(func(key, value any) bool {
+		teardownCall(key.(string), ReasonShutdown)
+		return true
+	})
+	if !settle(10*time.Second, func() bool { return liveCalls.len() == 0 && metrics.CallGoroutines.Load() == 0 }) {
+		t.Errorf("calls still tearing down: %d live, %d goroutines", liveCalls.len(), metrics.CallGoroutines.Load())
+	}
+	// Every call's callbacks are queued by now
+	pendingCallbacks.Wait()
+}
+
+// doJSON sends body as JSON to the app and decodes a JSON object reply
+func doJSON(t testing.TB, app *fiber.App, method, path string, body any) (*http.Response, map[string]any) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, int((30 * time.Second).Milliseconds()))
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var reply map[string]any
+	data, _ := io.ReadAll(resp.Body)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &reply); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, data, err)
+		}
+	}
+	return resp, reply
+}
+
+// offer places a minimal-mode offer and returns its call ID
+func offer(t testing.TB, app *fiber.App, extra map[string]any) (*http.Response, string) {
+	t.Helper()
+	body := map[string]any{"to": "15550001", "from": "15550002", "response_mode": ResponseModeMinimal}
+	for k, v := range extra {
+		body[k] = v
+	}
+	resp, reply := doJSON(t, app, fiber.MethodPost, "/load/offer", body)
+	callID, _ := reply["call_id"].(string)
+	return resp, callID
+}
+
+func TestNewAppServesRoutes(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	for _, path := range []string{"/healthz", "/stats", "/load/calls"} {
+		resp, _ := doJSON(t, app, fiber.MethodGet, path, nil)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, resp.StatusCode)
+		}
+	}
+
+	resp, callID := offer(t, app, nil)
+	if resp.StatusCode != fiber.StatusOK || callID == "" {
+		t.Fatalf("offer = %d with call_id %q", resp.StatusCode, callID)
+	}
+	if _, ok := ActionChannels.Load(callID); !ok {
+		t.Fatalf("call %s not registered", callID)
+	}
+
+	resp, _ = doJSON(t, app, fiber.MethodPost, "/load/action", map[string]any{
+		"call_id": callID, "action": "terminate", "messaging_product": "whatsapp",
+	})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("terminate = %d, want 200", resp.StatusCode)
+	}
+	if _, ok := ActionChannels.Load(callID); ok {
+		t.Errorf("call %s still registered after terminate", callID)
+	}
+}
+
+func TestNewAppRejectsBadICEServer(t *testing.T) {
+	cfg := defaultConfig
+	cfg.HostOnly = false
+	cfg.STUNServers = stringList{"not-a-url"}
+	if _, err := NewApp(cfg); err == nil {
+		t.Fatal("NewApp accepted an invalid STUN server")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// How a simulated callee resolves an offer under --auto-resolve
+const (
+	ResolveAccept   = "accept"   // answer from an in-process callee and stream media
+	ResolveReject   = "reject"   // tear the call down as if the callee declined
+	ResolveNoAnswer = "noanswer" // never answer; --answer-wait-max or auto-remove ends it
+)
+
+type autoResolveEntry struct {
+	outcome string
+	weight  int
+}
+
+// autoResolveMix is a flag.Value for weighted outcomes like "accept:60,reject:20,noanswer:20"
+type autoResolveMix []autoResolveEntry
+
+func (m *autoResolveMix) String() string {
+	parts := make([]string, len(*m))
+	for i, entry := range *m {
+		parts[i] = fmt.Sprintf("%s:%d", entry.outcome, entry.weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *autoResolveMix) Set(value string) error {
+	var mix autoResolveMix
+	total := 0
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		outcome, weightStr, ok := strings.Cut(item, ":")
+		if !ok {
+			return fmt.Errorf("auto-resolve entry %q must be outcome:weight", item)
+		}
+		switch outcome {
+		case ResolveAccept, ResolveReject, ResolveNoAnswer:
+		default:
+			return fmt.Errorf("invalid auto-resolve outcome %q (want %s, %s or %s)", outcome, ResolveAccept, ResolveReject, ResolveNoAnswer)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 0 {
+			return fmt.Errorf("auto-resolve weight for %s must be a non-negative integer", outcome)
+		}
+		total += weight
+		mix = append(mix, autoResolveEntry{outcome: outcome, weight: weight})
+	}
+	if total == 0 {
+		return fmt.Errorf("auto-resolve weights must not all be zero")
+	}
+	*m = mix
+	return nil
+}
+
+func (m autoResolveMix) pick() string {
+	total := 0
+	for _, entry := range m {
+		total += entry.weight
+	}
+	n := rand.N(total)
+	for _, entry := range m {
+		if n < entry.weight {
+			return entry.outcome
+		}
+		n -= entry.weight
+	}
+	return ResolveNoAnswer
+}
+
+// scheduleAutoResolve resolves an offer by itself after a delay sampled
+// from --auto-resolve-delay, in place of an accept or reject action
+func scheduleAutoResolve(callID string, details *CallIDDetails, offerSDP string) {
+	if len(config.AutoResolve) == 0 {
+		return
+	}
+	outcome := config.AutoResolve.pick()
+	delay := config.AutoResolveDelay.sample()
+
+	details.goTracked("auto_resolve", func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-details.ctx.Done():
+			return
+		}
+
+		metrics.recordAutoResolve(outcome)
+		log.Printf("%s Auto-resolving offer after %s: %s\n", callID, delay, outcome)
+		switch outcome {
+		case ResolveAccept:
+			if err := autoAccept(callID, details, offerSDP); err != nil {
+				log.Printf("❌ %s Error auto-accepting: %v\n", callID, err)
+				teardownCall(callID, ReasonAutoAcceptFailed)
+			}
+		case ResolveReject:
+			teardownCall(callID, ReasonReject)
+		}
+	})
+}
+
+// autoAccept answers the offer from an in-process callee PeerConnection,
+// which lives until the call ends, and delivers the answer as an accept
+func autoAccept(callID string, details *CallIDDetails, offerSDP string) error {
+	callee, err := webrtcAPI.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return err
+	}
+	details.goTracked("auto_callee", func() {
+		<-details.ctx.Done()
+		callee.Close()
+	})
+
+	// Drain received media so the callee behaves like a real endpoint
+	callee.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := track.ReadRTP(); err != nil {
+				return
+			}
+		}
+	})
+
+	if err := callee.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
+		return err
+	}
+	answer, err := callee.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(callee)
+	if err := callee.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	select {
+	case <-gatherComplete:
+	case <-details.ctx.Done():
+		return nil
+	}
+
+	if !details.transition(CallStateOffered, CallStateAccepted) {
+		details.transition(CallStateHalfOpen, CallStateAccepted)
+	}
+	select {
+	case details.ch <- ActionData{
+		Action: "accept",
+		Data:   SessionDescription{Type: "answer", SDP: callee.LocalDescription().SDP},
+	}:
+	case <-details.ctx.Done():
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // requests flow normally
+	BreakerOpen     = "open"      // shedding load until the cooldown ends
+	BreakerHalfOpen = "half_open" // one probe request allowed through
+)
+
+// CircuitBreaker sheds offers and answers after --breaker-threshold
+// consecutive PeerConnection setup failures, so an exhausted node gets a
+// --breaker-cooldown to recover instead of failing every request.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	state       string
+	failures    int
+	openedAt    time.Time
+	probeSentAt time.Time
+}
+
+var pcBreaker = &CircuitBreaker{state: BreakerClosed}
+
+// Allow reports whether a request may create a PeerConnection and, if
+// not, how long the client should wait before retrying
+func (b *CircuitBreaker) Allow() (bool, time.Duration) {
+	if config.BreakerThreshold == 0 {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if remaining := b.openedAt.Add(config.BreakerCooldown).Sub(now); remaining > 0 {
+			return false, remaining
+		}
+		b.state = BreakerHalfOpen
+		b.probeSentAt = now
+		log.Printf("🔌 Circuit breaker half-open, probing PeerConnection creation\n")
+		return true, 0
+	case BreakerHalfOpen:
+		// A probe that never reported back (it failed before creating a
+		// PeerConnection) must not keep the breaker shut forever
+		if now.Sub(b.probeSentAt) < config.BreakerCooldown {
+			return false, config.BreakerCooldown - now.Sub(b.probeSentAt)
+		}
+		b.probeSentAt = now
+		return true, 0
+	}
+	return true, 0
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		log.Printf("🔌 Circuit breaker closed, PeerConnection creation recovered\n")
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) Failure() {
+	if config.BreakerThreshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= config.BreakerThreshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		metrics.BreakerTrips.Add(1)
+		log.Printf("🔌 Circuit breaker open after %d consecutive PeerConnection failures, shedding load for %s\n", b.failures, config.BreakerCooldown)
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxBulkOffers      = 1000
+	maxBulkRetries     = 10
+	defaultBulkRetries = 3
+
+	// bulkRetryBase is the first backoff before retrying a failed offer;
+	// a Retry-After from the failure is honored up to bulkRetryMax
+	bulkRetryBase = 100 * time.Millisecond
+	bulkRetryMax  = 2 * time.Second
+)
+
+// BulkOfferRequest creates Count offers from the Offer template. With
+// RetryFailed, an offer that fails transiently (429, 5xx) is retried up
+// to MaxRetries times (default 3) before it is reported as failed.
+type BulkOfferRequest struct {
+	Count       int          `json:"count"`
+	Offer       OfferRequest `json:"offer"`
+	RetryFailed bool         `json:"retry_failed,omitempty"`
+	MaxRetries  int          `json:"max_retries,omitempty"`
+}
+
+// BulkOfferResult is one call of a bulk request, created or not
+type BulkOfferResult struct {
+	CallID   string `json:"call_id,omitempty"`
+	SDP      string `json:"sdp,omitempty"`
+	Status   int    `json:"status"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BulkOfferResponse struct {
+	Requested int               `json:"requested"`
+	Created   int               `json:"created"`
+	Failed    int               `json:"failed"`
+	Calls     []BulkOfferResult `json:"calls"`
+}
+
+func processBulkOffer(c *fiber.Ctx) error {
+	var request BulkOfferRequest
+	if err := parseBody(c, &request); err != nil {
+		return send(c, fiber.StatusBadRequest, fiber.Map{"error": "Invalid request"})
+	}
+	response, err := handleBulkOffer(request)
+	setCapacityHeaders(c)
+	return respond(c, response, err)
+}
+
+func handleBulkOffer(request BulkOfferRequest) (any, error) {
+	if request.Count < 1 || request.Count > maxBulkOffers {
+		return nil, newRequestError(fiber.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxBulkOffers))
+	}
+	if request.Offer.CallID != "" && request.Count > 1 {
+		return nil, newRequestError(fiber.StatusBadRequest, "offer.call_id can't be shared by several calls")
+	}
+	if request.MaxRetries < 0 || request.MaxRetries > maxBulkRetries {
+		return nil, newRequestError(fiber.StatusBadRequest, fmt.Sprintf("max_retries must be between 0 and %d", maxBulkRetries))
+	}
+	retries := 0
+	if request.RetryFailed {
+		retries = request.MaxRetries
+		if retries == 0 {
+			retries = defaultBulkRetries
+		}
+	}
+
+	// Each result carries the call's SDP, so the template's shape is moot
+	request.Offer.ResponseMode = ResponseModeMinimal
+
+	response := BulkOfferResponse{Requested: request.Count, Calls: make([]BulkOfferResult, request.Count)}
+	for i := range response.Calls {
+		result := bulkOffer(request.Offer, retries)
+		if result.Status == fiber.StatusOK {
+			response.Created++
+		} else {
+			response.Failed++
+		}
+		response.Calls[i] = result
+	}
+	log.Printf("📩 Bulk offer: %d of %d calls created\n", response.Created, response.Requested)
+	return response, nil
+}
+
+// bulkOffer places one offer, retrying transient failures up to retries times
+func bulkOffer(template OfferRequest, retries int) BulkOfferResult {
+	var result BulkOfferResult
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		response, err := handleOffer(template)
+		if err == nil {
+			offer := response.(MinimalOfferResponse)
+			result.CallID, result.SDP, result.Status, result.Error = offer.CallID, offer.SDP, fiber.StatusOK, ""
+			return result
+		}
+
+		result.Status = fiber.StatusInternalServerError
+		result.Error = err.Error()
+		var retryAfter time.Duration
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			result.Status = reqErr.status
+			retryAfter = reqErr.retryAfter
+			if message, ok := reqErr.body["error"].(string); ok {
+				result.Error = message
+			}
+		}
+
+		transient := result.Status == fiber.StatusTooManyRequests || result.Status >= fiber.StatusInternalServerError
+		if attempt > retries || !transient || draining.Load() {
+			return result
+		}
+		delay := max(callbackBackoff(bulkRetryBase, attempt), min(retryAfter, bulkRetryMax))
+		log.Printf("🔄 Bulk offer attempt %d failed with %d, retrying in %s: %s\n", attempt, result.Status, delay.Round(time.Millisecond), result.Error)
+		if !sleepContext(callsCtx, delay) {
+			return result
+		}
+	}
+}
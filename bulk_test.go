@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func bulkOffers(t *testing.T, app *fiber.App, body map[string]any) BulkOfferResponse {
+	t.Helper()
+	resp, reply := doJSON(t, app, fiber.MethodPost, "/load/offer/bulk", body)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("bulk offer = %d: %v", resp.StatusCode, reply)
+	}
+	data, _ := json.Marshal(reply)
+	var response BulkOfferResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestBulkOffer(t *testing.T) {
+	app := newTestApp(t, nil)
+	template := map[string]any{"to": "15550001", "from": "15550002"}
+
+	response := bulkOffers(t, app, map[string]any{"count": 3, "offer": template})
+	if response.Requested != 3 || response.Created != 3 || response.Failed != 0 {
+		t.Fatalf("response = %+v, want 3 created", response)
+	}
+	for _, call := range response.Calls {
+		if call.Attempts != 1 || call.SDP == "" {
+			t.Errorf("call = %+v, want one attempt and an SDP", call)
+		}
+		if _, ok := ActionChannels.Load(call.CallID); !ok {
+			t.Errorf("call %s not registered", call.CallID)
+		}
+	}
+
+	for name, body := range map[string]map[string]any{
+		"no count":          {"offer": template},
+		"too many":          {"count": maxBulkOffers + 1, "offer": template},
+		"shared call_id":    {"count": 2, "offer": map[string]any{"to": "15550001", "call_id": "same"}},
+		"too many retries":  {"count": 1, "offer": template, "retry_failed": true, "max_retries": maxBulkRetries + 1},
+		"negative retries":  {"count": 1, "offer": template, "max_retries": -1},
+		"invalid each call": {"count": 1, "offer": map[string]any{"to": "15550001", "dtmf_sequence": "1"}},
+	} {
+		resp, _ := doJSON(t, app, fiber.MethodPost, "/load/offer/bulk", body)
+		want := fiber.StatusBadRequest
+		if name == "invalid each call" {
+			// Reported per call; a bad request is not retried
+			want = fiber.StatusOK
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", name, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestBulkOfferRetriesFailed(t *testing.T) {
+	app := newTestApp(t, func(cfg *Config) { cfg.MaxCalls = 2 })
+	template := map[string]any{"to": "15550001", "from": "15550002"}
+
+	// One slot left: without retries the second call is refused
+	_, blocker := offer(t, app, nil)
+	response := bulkOffers(t, app, map[string]any{"count": 2, "offer": template})
+	if response.Created != 1 || response.Failed != 1 {
+		t.Fatalf("response = %+v, want 1 created and 1 failed", response)
+	}
+	if failed := response.Calls[1]; failed.Status != fiber.StatusTooManyRequests || failed.Attempts != 1 || failed.Error == "" {
+		t.Errorf("failed call = %+v, want one 429 attempt with its error", failed)
+	}
+	teardownCall(response.Calls[0].CallID, ReasonTerminate)
+
+	// The slot frees up while the second call is backing off
+	time.AfterFunc(300*time.Millisecond, func() { teardownCall(blocker, ReasonTerminate) })
+	response = bulkOffers(t, app, map[string]any{"count": 2, "offer": template, "retry_failed": true, "max_retries": 2})
+	if response.Created != 2 || response.Failed != 0 {
+		t.Fatalf("response = %+v, want 2 created", response)
+	}
+	if attempts := response.Calls[1].Attempts; attempts != 2 {
+		t.Errorf("second call took %d attempts, want 2", attempts)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// callbackDelay returns the simulated network latency to apply before a callback
+func callbackDelay() time.Duration {
+	cfg := currentConfig()
+	delay := cfg.CallbackDelay
+	if cfg.CallbackDelayJitter > 0 {
+		delay += rand.N(cfg.CallbackDelayJitter)
+	}
+	return delay
+}
+
+// pendingCallbacks counts callbacks not yet delivered or abandoned, so
+// shutdown can let the final terminate callbacks go out. callbacksFlushed
+// is set, under callbacksMu, once shutdown starts waiting on it; later
+// callbacks are dropped so no Add can race that Wait.
+var (
+	pendingCallbacks sync.WaitGroup
+	callbacksMu      sync.Mutex
+	callbacksFlushed bool
+)
+
+// sendCallbackAsync delivers payload in the background after latency plus
+// the --callback-delay. Cancelling ctx abandons the callback, including any
+// delay or retry still pending.
+func sendCallbackAsync(ctx context.Context, callbackURL string, payload Event, latency time.Duration) {
+	callbacksMu.Lock()
+	if callbacksFlushed {
+		callbacksMu.Unlock()
+		log.Printf("Callback to %s dropped, server is shutting down\n", callbackURL)
+		return
+	}
+	pendingCallbacks.Add(1)
+	callbacksMu.Unlock()
+
+	go func() { // Fire and forget
+		defer pendingCallbacks.Done()
+		if !sleepContext(ctx, latency+callbackDelay()) {
+			log.Printf("Callback to %s cancelled, call already ended\n", callbackURL)
+			return
+		}
+
+		jsonData, err := currentAPIScheme().encode(payload)
+		if err != nil {
+			log.Printf("Error encoding callback payload: %v\n", err)
+			return
+		}
+		metrics.recordCallback(callbackURL, deliverCallback(ctx, payloadCallID(payload), callbackURL, jsonData))
+	}()
+}
+
+// callbackSignature is the X-Hub-Signature-256 value for body: an
+// HMAC-SHA256 keyed with secret over the exact bytes sent
+func callbackSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// payloadCallID is the id of the call a webhook reports, for logging
+func payloadCallID(payload Event) string {
+	if len(payload.Entry) == 0 || len(payload.Entry[0].Changes) == 0 || len(payload.Entry[0].Changes[0].Value.Calls) == 0 {
+		return ""
+	}
+	return payload.Entry[0].Changes[0].Value.Calls[0].ID
+}
+
+// Outcomes of deliverCallback
+const (
+	CallbackDelivered = "delivered" // final response was 2xx
+	CallbackFailed    = "failed"    // request error or non-2xx final response
+	CallbackCancelled = "cancelled" // the call ended first
+)
+
+// deliverCallback POSTs body to callbackURL for callID. Connection errors
+// and 5xx responses are retried with exponential backoff from
+// --callback-retry-base, and a 429 or 503 carrying Retry-After after the
+// requested delay (capped by --callback-retry-after-max), up to
+// --callback-max-attempts. Other 4xx responses are final.
+func deliverCallback(ctx context.Context, callID, callbackURL string, body []byte) string {
+	cfg := currentConfig()
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", callbackURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Error creating callback request: %v\n", err)
+			return CallbackFailed
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if cfg.CallbackSecret != "" {
+			req.Header.Set("X-Hub-Signature-256", callbackSignature(body, cfg.CallbackSecret))
+		}
+
+		var wait time.Duration
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("%s Callback to %s cancelled, call already ended\n", callID, callbackURL)
+				return CallbackCancelled
+			}
+			log.Printf("❌ %s Callback attempt %d/%d to %s failed: %v\n", callID, attempt, cfg.CallbackMaxAttempts, callbackURL, err)
+			wait = callbackBackoff(cfg.CallbackRetryBase, attempt)
+		} else {
+			resp.Body.Close()
+
+			// body, _ := io.ReadAll(resp.Body)
+			// log.Printf("Callback response: %s\n", string(body))
+			log.Printf("%s Callback attempt %d/%d response status: %d\n", callID, attempt, cfg.CallbackMaxAttempts, resp.StatusCode)
+
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return CallbackDelivered
+			}
+			retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			switch {
+			case hasRetryAfter && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable):
+				wait = min(retryAfter, cfg.CallbackRetryAfterMax)
+			case resp.StatusCode >= 500:
+				wait = callbackBackoff(cfg.CallbackRetryBase, attempt)
+			default:
+				return CallbackFailed
+			}
+		}
+
+		if attempt >= cfg.CallbackMaxAttempts {
+			log.Printf("❌ %s Giving up on callback to %s after %d attempts\n", callID, callbackURL, attempt)
+			return CallbackFailed
+		}
+		log.Printf("🔄 %s Retrying callback in %s\n", callID, wait)
+		if !sleepContext(ctx, wait) {
+			log.Printf("%s Callback to %s cancelled, call already ended\n", callID, callbackURL)
+			return CallbackCancelled
+		}
+	}
+}
+
+// callbackBackoff is the wait before retry number attempt: base doubled
+// per earlier attempt, jittered down by up to half so retries from many
+// calls spread out
+func callbackBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := base << (attempt - 1)
+	if backoff <= 0 {
+		return base
+	}
+	return backoff - rand.N(backoff/2+1)
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either as delay-seconds
+// or as an HTTP-date (RFC 9110 §10.2.3)
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
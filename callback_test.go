@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run with -race: callbacks queued while shutdown flushes must either be
+// waited for or dropped, never added to the WaitGroup mid-Wait
+func TestFlushCallbacksDuringSends(t *testing.T) {
+	newTestApp(t, nil)
+	t.Cleanup(func() {
+		callbacksMu.Lock()
+		callbacksFlushed = false
+		callbacksMu.Unlock()
+	})
+
+	var delivered atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(1)
+	}))
+	defer server.Close()
+
+	stop := make(chan struct{})
+	var senders sync.WaitGroup
+	for range 8 {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				sendCallbackAsync(context.Background(), server.URL, Event{}, 0)
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	flushCallbacks(5 * time.Second)
+	flushed := delivered.Load()
+	// Keep sending past the flush; none of it may go out
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	senders.Wait()
+
+	time.Sleep(100 * time.Millisecond)
+	if got := delivered.Load(); got != flushed {
+		t.Errorf("%d callbacks delivered after the flush returned", got-flushed)
+	}
+}
+
+func TestCallbackSignature(t *testing.T) {
+	got := callbackSignature([]byte("The quick brown fox jumps over the lazy dog"), "key")
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got != want {
+		t.Errorf("callbackSignature = %s, want %s", got, want)
+	}
+}
+
+func TestDeliverCallbackSignsBody(t *testing.T) {
+	newTestApp(t, func(cfg *Config) { cfg.CallbackSecret = "key" })
+
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+	}))
+	defer server.Close()
+
+	body := []byte("The quick brown fox jumps over the lazy dog")
+	deliverCallback(context.Background(), "call-1", server.URL, body)
+	header := <-headers
+	if got, want := header.Get("X-Hub-Signature-256"), callbackSignature(body, "key"); got != want {
+		t.Errorf("X-Hub-Signature-256 = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+type CallState string
+
+const (
+	CallStateOffered  CallState = "offered"
+	CallStateHalfOpen CallState = "half_open" // offered and ICE connected, but never accepted
+	CallStateAccepted CallState = "accepted"
+	CallStateAnswered CallState = "answered"
+	CallStateClosed   CallState = "closed"
+)
+
+const (
+	DirectionUserInitiated     = "USER_INITIATED"
+	DirectionBusinessInitiated = "BUSINESS_INITIATED"
+)
+
+// Teardown reasons recorded in metrics and the CDR
+const (
+	ReasonTimeout           = "timeout"
+	ReasonHalfOpen          = "half_open"
+	ReasonAnswerWaitTimeout = "answer_wait_timeout"
+	ReasonConnectTimeout    = "connect_timeout"
+	ReasonShutdown          = "shutdown"
+	ReasonTerminate         = "terminate"
+	ReasonReject            = "reject"
+	ReasonHangup            = "hangup"
+	ReasonDurationElapsed   = "duration_elapsed"
+	ReasonGlare             = "glare"
+	ReasonAutoAcceptFailed  = "auto_accept_failed"
+	ReasonEvicted           = "evicted"
+	ReasonProbeDone         = "probe_done"
+)
+
+func newCallIDDetails(pc *webrtc.PeerConnection, direction string, state CallState, scenario string) *CallIDDetails {
+	ctx, cancel := context.WithCancel(callsCtx)
+	now := time.Now()
+	return &CallIDDetails{
+		pc:        pc,
+		ch:        make(chan ActionData, 1),
+		direction: direction,
+		createdAt: now,
+		scenario:  scenario,
+		ctx:       ctx,
+		cancel:    cancel,
+		state:     state,
+		timeline:  []TimelineEvent{{At: now, Event: TimelineCreated, Detail: string(state)}},
+		running:   map[string]int{},
+		connected: make(chan struct{}),
+
+		connectionState: webrtc.PeerConnectionStateNew,
+	}
+}
+
+func (d *CallIDDetails) State() CallState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+func (d *CallIDDetails) setState(state CallState) {
+	d.mu.Lock()
+	d.state = state
+	d.mu.Unlock()
+}
+
+// transition moves the call to `to` only if it is currently in `from`
+func (d *CallIDDetails) transition(from, to CallState) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state != from {
+		return false
+	}
+	d.state = to
+	return true
+}
+
+// Timeline event names
+const (
+	TimelineCreated              = "created"
+	TimelineHalfOpen             = "half_open"
+	TimelinePreAccepted          = "pre_accepted"
+	TimelineAccepted             = "accepted"
+	TimelineAcceptJitter         = "accept_jitter"
+	TimelineRemoteDescriptionSet = "remote_description_set"
+	TimelineConnected            = "connected"
+	TimelineConnectionState      = "connection_state"
+	TimelineDurationPlanned      = "duration_planned"
+	TimelineGlare                = "glare"
+	TimelineMuted                = "muted"
+	TimelineUnmuted              = "unmuted"
+	TimelineClosed               = "closed"
+)
+
+// TimelineEvent is one timestamped step in a call's life
+type TimelineEvent struct {
+	At     time.Time `json:"at"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+func (d *CallIDDetails) addTimeline(event string, detail string) {
+	d.mu.Lock()
+	d.timeline = append(d.timeline, TimelineEvent{At: time.Now(), Event: event, Detail: detail})
+	d.mu.Unlock()
+}
+
+func (d *CallIDDetails) Timeline() []TimelineEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]TimelineEvent(nil), d.timeline...)
+}
+
+// recordICECredentials keeps the call's local ICE credentials for stats and
+// logs them, when --expose-ice-credentials is set. Call before the call is
+// registered.
+func (d *CallIDDetails) recordICECredentials(callID string) {
+	if !config.ExposeICECredentials {
+		return
+	}
+	creds, err := iceCredentials(d.pc.LocalDescription().SDP)
+	if err != nil {
+		log.Printf("%s Could not read ICE credentials: %v\n", callID, err)
+		return
+	}
+	d.iceCredentials = &creds
+	log.Printf("🔑 %s ICE ufrag=%s pwd=%s\n", callID, creds.Ufrag, creds.Pwd)
+}
+
+// watchConnectionState records each PeerConnection state transition in the
+// call's timeline and publishes it. Unlike the ICE state, this one only
+// reaches connected once DTLS is up too, so media can actually flow.
+func (d *CallIDDetails) watchConnectionState(callID string) {
+	d.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		d.mu.Lock()
+		d.connectionState = state
+		d.mu.Unlock()
+		d.addTimeline(TimelineConnectionState, state.String())
+		debugf("%s Connection state has changed: %s", callID, state)
+		events.Publish(LifecycleEvent{
+			Type:            EventConnectionState,
+			CallID:          callID,
+			Direction:       d.direction,
+			Scenario:        d.scenario,
+			Details:         d,
+			ConnectionState: state,
+		})
+	})
+}
+
+func (d *CallIDDetails) ConnectionState() webrtc.PeerConnectionState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connectionState
+}
+
+// markConnected records the call's first ICE-connected event and wakes
+// anyone blocked in waitForConnect
+func (d *CallIDDetails) markConnected(callID string) {
+	d.connectedOnce.Do(func() {
+		d.addTimeline(TimelineConnected, "")
+		close(d.connected)
+		startStatsSampling(d)
+		publishCallEvent(EventConnected, callID, d)
+	})
+}
+
+// markHalfOpen moves an offered call whose ICE connected before any
+// accept to half-open. It reports whether the call moved.
+func (d *CallIDDetails) markHalfOpen(callID string) bool {
+	if !d.transition(CallStateOffered, CallStateHalfOpen) {
+		return false
+	}
+	metrics.HalfOpenCalls.Add(1)
+	d.addTimeline(TimelineHalfOpen, "")
+	log.Printf("%s ICE connected before accept, call is half-open\n", callID)
+	return true
+}
+
+// watchHalfOpen marks an offered call half-open once its ICE connects
+// without an accept (a pre_accept answer is enough for that) and, with a
+// timeout, tears it down if the accept has still not come that long later
+func watchHalfOpen(callID string, details *CallIDDetails, timeout time.Duration) {
+	details.goTracked("half_open_watch", func() {
+		select {
+		case <-details.connected:
+		case <-details.ctx.Done():
+			return
+		}
+		if !details.markHalfOpen(callID) || timeout <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-details.ctx.Done():
+			return
+		}
+		if details.State() == CallStateHalfOpen {
+			log.Printf("%s No accept within %s of ICE connecting\n", callID, timeout)
+			teardownCall(callID, ReasonHalfOpen)
+		}
+	})
+}
+
+// addRemoteCandidate applies a trickled remote candidate, or queues it
+// until setRemoteDescription when the answer has not been applied yet.
+// It reports whether the candidate was queued.
+func (d *CallIDDetails) addRemoteCandidate(candidate webrtc.ICECandidateInit) (bool, error) {
+	d.mu.Lock()
+	if d.pc.RemoteDescription() == nil {
+		d.pendingCandidates = append(d.pendingCandidates, candidate)
+		d.mu.Unlock()
+		return true, nil
+	}
+	d.mu.Unlock()
+	return false, d.pc.AddICECandidate(candidate)
+}
+
+// setRemoteDescription applies the remote answer and then any candidates
+// trickled in ahead of it
+func (d *CallIDDetails) setRemoteDescription(callID string, answerSDP string) error {
+	if err := d.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answerSDP}); err != nil {
+		return err
+	}
+	d.addTimeline(TimelineRemoteDescriptionSet, "")
+
+	d.mu.Lock()
+	pending := d.pendingCandidates
+	d.pendingCandidates = nil
+	d.mu.Unlock()
+	for _, candidate := range pending {
+		if err := d.pc.AddICECandidate(candidate); err != nil {
+			log.Printf("❌ %s Error adding queued candidate: %v\n", callID, err)
+		}
+	}
+	return nil
+}
+
+// Results of waitForConnect
+const (
+	ConnectStatusConnected = "connected"
+	ConnectStatusFailed    = "failed"
+	ConnectStatusTimeout   = "timeout"
+)
+
+// waitForConnect blocks until the call's ICE connects, the call is torn
+// down, or timeout passes
+func waitForConnect(callID string, timeout time.Duration) string {
+	val, ok := ActionChannels.Load(callID)
+	if !ok {
+		return ConnectStatusFailed
+	}
+	details := val.(*CallIDDetails)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-details.connected:
+		return ConnectStatusConnected
+	case <-details.ctx.Done():
+		return ConnectStatusFailed
+	case <-timer.C:
+		return ConnectStatusTimeout
+	}
+}
+
+// goTracked runs fn on a new goroutine that is counted against the call
+// until it returns, so leaks show up in stats and after teardown. fn must
+// return soon after ctx is done: teardown waits for it before closing the
+// PeerConnection.
+func (d *CallIDDetails) goTracked(name string, fn func()) {
+	// Once markClosed has run teardown may already be waiting, and a
+	// goroutine started now sees ctx done at once anyway
+	d.mu.Lock()
+	waited := d.state != CallStateClosed
+	if waited {
+		d.stopped.Add(1)
+	}
+	d.mu.Unlock()
+
+	d.track(name, func() {
+		if waited {
+			defer d.stopped.Done()
+		}
+		fn()
+	})
+}
+
+// goUntilClosed is goTracked for goroutines that block on the
+// PeerConnection itself, such as RTCP reads, and so only exit once it is
+// closed. Teardown does not wait for them.
+func (d *CallIDDetails) goUntilClosed(name string, fn func()) {
+	d.track(name, fn)
+}
+
+func (d *CallIDDetails) track(name string, fn func()) {
+	d.goroutines.Add(1)
+	metrics.CallGoroutines.Add(1)
+	d.mu.Lock()
+	d.running[name]++
+	d.mu.Unlock()
+
+	go func() {
+		defer func() {
+			d.mu.Lock()
+			if d.running[name]--; d.running[name] == 0 {
+				delete(d.running, name)
+			}
+			d.mu.Unlock()
+			metrics.CallGoroutines.Add(-1)
+			d.goroutines.Add(-1)
+		}()
+		fn()
+	}()
+}
+
+// runningGoroutines returns the names of this call's live goroutines
+func (d *CallIDDetails) runningGoroutines() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	running := make(map[string]int, len(d.running))
+	for name, count := range d.running {
+		running[name] = count
+	}
+	return running
+}
+
+// verifyGoroutinesExited reports a leak if the call still has goroutines
+// running once --leak-check-delay has passed since teardown
+func verifyGoroutinesExited(callID string, details *CallIDDetails) {
+	if config.LeakCheckDelay <= 0 {
+		return
+	}
+	time.AfterFunc(config.LeakCheckDelay, func() {
+		if n := details.goroutines.Load(); n > 0 {
+			metrics.GoroutineLeaks.Add(1)
+			log.Printf("⚠️ %s %d goroutine(s) still running %s after teardown: %v\n", callID, n, config.LeakCheckDelay, details.runningGoroutines())
+		}
+	})
+}
+
+// awaitStopped waits up to timeout for the goroutines started with
+// goTracked to exit after ctx is cancelled, so none of them is still
+// writing media or signaling when the PeerConnection closes
+func (d *CallIDDetails) awaitStopped(callID string, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		d.stopped.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("⚠️ %s Closing PeerConnection with goroutines still running after %s: %v\n", callID, timeout, d.runningGoroutines())
+	}
+}
+
+// markClosed moves the call to closed, recording why, and returns the state
+// it was in
+func (d *CallIDDetails) markClosed(reason string) CallState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	finalState := d.state
+	d.state = CallStateClosed
+	d.timeline = append(d.timeline, TimelineEvent{At: time.Now(), Event: TimelineClosed, Detail: reason})
+	return finalState
+}
+
+// teardownCall removes the call from the registry, closes its PeerConnection
+// and records why it ended. It is safe to call more than once per call_id.
+func teardownCall(callID string, reason string) bool {
+	val, ok := ActionChannels.LoadAndDelete(callID)
+	if !ok {
+		return false
+	}
+	details := val.(*CallIDDetails)
+
+	finalState := details.markClosed(reason)
+	details.cancel()
+
+	// The call is already unregistered; closing can wait for in-flight RTCP
+	// to land so the CDR's final stats are not truncated. Shutdown can't wait.
+	cfg := currentConfig()
+	delay := cfg.CloseStatsDelay
+	switch {
+	case delay > 0 && reason != ReasonShutdown:
+		time.AfterFunc(delay, func() {
+			details.awaitStopped(callID, cfg.TeardownWait)
+			finishTeardown(callID, details, finalState, reason, true)
+		})
+	case cfg.TeardownWait > 0 && reason != ReasonShutdown:
+		// teardownCall is often called from one of the call's own
+		// goroutines, so the wait must not run on the caller's stack
+		go func() {
+			details.awaitStopped(callID, cfg.TeardownWait)
+			finishTeardown(callID, details, finalState, reason, false)
+		}()
+	default:
+		details.awaitStopped(callID, cfg.TeardownWait)
+		finishTeardown(callID, details, finalState, reason, delay > 0)
+	}
+	log.Printf("%s Call torn down: %s\n", callID, reason)
+	return true
+}
+
+func finishTeardown(callID string, details *CallIDDetails, finalState CallState, reason string, withStats bool) {
+	var finalStats *FinalMediaStats
+	if withStats {
+		finalStats = collectFinalStats(details)
+	}
+	closePeerConnection(callID, details)
+	verifyGoroutinesExited(callID, details)
+
+	record := newCallDetailRecord(callID, details, finalState, reason)
+	record.FinalStats = finalStats
+	events.Publish(LifecycleEvent{
+		Type:      EventTerminated,
+		CallID:    callID,
+		Direction: details.direction,
+		Scenario:  details.scenario,
+		Details:   details,
+		Record:    &record,
+	})
+}
+
+// closePeerConnection closes the call's PeerConnection. With
+// --graceful-track-close it first sends an RTCP BYE for the audio stream and
+// stops the sender, then waits out the grace period in the background so the
+// remote sees a clean end of stream instead of the media just vanishing.
+func closePeerConnection(callID string, details *CallIDDetails) {
+	cfg := currentConfig()
+	if !cfg.GracefulTrackClose || details.sender == nil {
+		details.pc.Close()
+		return
+	}
+
+	go func() {
+		if encodings := details.sender.GetParameters().Encodings; len(encodings) > 0 {
+			bye := &rtcp.Goodbye{Sources: []uint32{uint32(encodings[0].SSRC)}}
+			if err := details.pc.WriteRTCP([]rtcp.Packet{bye}); err != nil {
+				log.Printf("%s Error sending RTCP BYE: %v\n", callID, err)
+			}
+		}
+		if err := details.sender.Stop(); err != nil {
+			log.Printf("%s Error stopping audio sender: %v\n", callID, err)
+		}
+		time.Sleep(cfg.TrackCloseGrace)
+		details.pc.Close()
+	}()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// offerSDP places a minimal-mode offer and returns its call ID and SDP
+func offerSDP(t testing.TB, app *fiber.App) (string, string) {
+	t.Helper()
+	resp, reply := doJSON(t, app, fiber.MethodPost, "/load/offer", map[string]any{
+		"to": "15550001", "from": "15550002", "response_mode": ResponseModeMinimal,
+	})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("offer = %d: %v", resp.StatusCode, reply)
+	}
+	callID, _ := reply["call_id"].(string)
+	sdp, _ := reply["sdp"].(string)
+	return callID, sdp
+}
+
+// answerSDP answers offer from a real PeerConnection, closed when the
+// test ends, so an accepted call connects and streams
+func answerSDP(t testing.TB, offer string) string {
+	t.Helper()
+	pc := answerPeer(t, offer)
+	t.Cleanup(func() { pc.Close() })
+	return pc.LocalDescription().SDP
+}
+
+// deadAnswerSDP is a valid answer from a peer that is gone before the
+// accept, so ICE can never connect
+func deadAnswerSDP(t testing.TB, offer string) string {
+	t.Helper()
+	pc := answerPeer(t, offer)
+	sdp := pc.LocalDescription().SDP
+	pc.Close()
+	return sdp
+}
+
+func answerPeer(t testing.TB, offer string) *webrtc.PeerConnection {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return pc
+}
+
+func acceptAction(callID, sdp string) ActionRequest {
+	return ActionRequest{
+		CallID:           callID,
+		Action:           "accept",
+		Session:          map[string]any{"sdp_type": "answer", "sdp": sdp},
+		MessagingProduct: "whatsapp",
+	}
+}
+
+// Teardown racing an accept must neither panic nor leave media streaming
+// on the torn-down call
+func TestTeardownRacingAccept(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	// Later rounds give the accept a head start, up to letting media flow
+	for i := range 10 {
+		callID, sdp := offerSDP(t, app)
+		accept := acceptAction(callID, answerSDP(t, sdp))
+
+		var race sync.WaitGroup
+		race.Add(2)
+		go func() {
+			defer race.Done()
+			handleAction(accept)
+		}()
+		go func() {
+			defer race.Done()
+			time.Sleep(time.Duration(i*i) * time.Millisecond)
+			teardownCall(callID, ReasonTerminate)
+		}()
+		race.Wait()
+	}
+
+	if !settle(10*time.Second, func() bool { return liveCalls.len() == 0 && metrics.CallGoroutines.Load() == 0 }) {
+		t.Fatalf("calls still running after teardown: %d live, %d goroutines", liveCalls.len(), metrics.CallGoroutines.Load())
+	}
+	streamed := metrics.SamplesStreamed.Load()
+	time.Sleep(200 * time.Millisecond)
+	if got := metrics.SamplesStreamed.Load(); got != streamed {
+		t.Errorf("%d samples streamed after every call was torn down", got-streamed)
+	}
+}
+
+// A call terminated by hand must not be torn down, reported or called
+// back about a second time when its auto-remove timeout comes due
+func TestTerminateBeforeAutoRemove(t *testing.T) {
+	var terminates atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err == nil && payloadCallEvent(event) == "terminate" {
+			terminates.Add(1)
+		}
+	}))
+	defer server.Close()
+	app := newTestApp(t, nil)
+
+	resp, callID := offer(t, app, map[string]any{"timeout_seconds": 1, "callback_url": server.URL})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("offer = %d, want 200", resp.StatusCode)
+	}
+	resp, _ = doJSON(t, app, fiber.MethodPost, "/load/action", map[string]any{
+		"call_id": callID, "action": "terminate", "messaging_product": "whatsapp",
+	})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("terminate = %d, want 200", resp.StatusCode)
+	}
+
+	// Past the 1s auto-remove timeout
+	time.Sleep(1500 * time.Millisecond)
+	if n := liveCalls.terminations(callID); n != 1 {
+		t.Errorf("call torn down %d times, want 1", n)
+	}
+	if n := terminates.Load(); n != 1 {
+		t.Errorf("%d terminate callbacks, want 1", n)
+	}
+}
+
+func payloadCallEvent(event Event) string {
+	if len(event.Entry) == 0 || len(event.Entry[0].Changes) == 0 || len(event.Entry[0].Changes[0].Value.Calls) == 0 {
+		return ""
+	}
+	return event.Entry[0].Changes[0].Value.Calls[0].Event
+}
+
+// Offers terminated before any answer must release every goroutine at
+// once, not when their call timeout would have fired
+func TestTerminatedOffersReleaseGoroutines(t *testing.T) {
+	app := newTestApp(t, nil)
+	terminate := func(callID string) {
+		resp, _ := doJSON(t, app, fiber.MethodPost, "/load/action", map[string]any{
+			"call_id": callID, "action": "terminate", "messaging_product": "whatsapp",
+		})
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("terminate = %d, want 200", resp.StatusCode)
+		}
+	}
+
+	// One call first, so goroutines started once per process aren't counted
+	_, callID := offer(t, app, nil)
+	terminate(callID)
+	teardownAllCalls(t)
+	baseline := runtime.NumGoroutine()
+
+	var callIDs []string
+	for range 10 {
+		resp, callID := offer(t, app, nil)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("offer = %d, want 200", resp.StatusCode)
+		}
+		callIDs = append(callIDs, callID)
+	}
+	if runtime.NumGoroutine() <= baseline {
+		t.Fatalf("no goroutines started for 10 offers")
+	}
+	for _, callID := range callIDs {
+		terminate(callID)
+	}
+
+	// Well inside the default 45s call timeout
+	if !settle(10*time.Second, func() bool { return runtime.NumGoroutine() <= baseline }) {
+		buf := make([]byte, 1<<20)
+		t.Fatalf("%d goroutines after terminating, baseline %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// A half-open call is an offer whose ICE connected (here from a pre_accept
+// answer) but that never got its accept
+func TestHalfOpenCalls(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		actions      []string
+		answer       func(testing.TB, string) string
+		teardown     bool
+		want         string
+		wantHalfOpen int64
+	}{
+		{"torn down by --half-open-timeout", 300 * time.Millisecond, []string{"pre_accept"}, answerSDP, false, OutcomeHalfOpen, 1},
+		{"terminated while half-open", 0, []string{"pre_accept"}, answerSDP, true, OutcomeHalfOpen, 1},
+		{"accepted after connecting", 5 * time.Second, []string{"pre_accept", "accept"}, answerSDP, true, OutcomeCompleted, 1},
+		{"accepted and connected", 300 * time.Millisecond, []string{"accept"}, answerSDP, true, OutcomeCompleted, 0},
+		{"terminated before connecting", 300 * time.Millisecond, []string{"accept"}, deadAnswerSDP, true, OutcomeCompleted, 0},
+		{"terminated unanswered", 300 * time.Millisecond, nil, answerSDP, true, OutcomeNoAnswer, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(cfg *Config) {
+				cfg.HalfOpenTimeout = tt.timeout
+				cfg.EarlyMediaFile = "output20ms.ogg"
+			})
+			halfOpen := metrics.HalfOpenCalls.Load()
+
+			callID, sdp := offerSDP(t, app)
+			answer := tt.answer(t, sdp)
+			for _, action := range tt.actions {
+				request := acceptAction(callID, answer)
+				request.Action = action
+				if _, err := handleAction(request); err != nil {
+					t.Fatalf("%s: %v", action, err)
+				}
+				// Past the half-open timeout, and long enough for a live peer to connect
+				time.Sleep(time.Second)
+			}
+			if tt.teardown {
+				teardownCall(callID, ReasonTerminate)
+			}
+
+			if !settle(5*time.Second, func() bool { return liveCalls.terminations(callID) == 1 }) {
+				t.Fatalf("call not torn down")
+			}
+			if got := liveCalls.outcome(callID); got != tt.want {
+				t.Errorf("outcome = %s, want %s", got, tt.want)
+			}
+			if got := metrics.HalfOpenCalls.Load() - halfOpen; got != tt.wantHalfOpen {
+				t.Errorf("half_open_calls grew by %d, want %d", got, tt.wantHalfOpen)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	OutcomeCompleted = "completed"
+	OutcomeRejected  = "rejected"
+	OutcomeNoAnswer  = "no_answer"
+	OutcomeHalfOpen  = "half_open"
+)
+
+// CallDetailRecord is emitted once per call when it is torn down
+type CallDetailRecord struct {
+	CallID     string    `json:"call_id"`
+	Direction  string    `json:"direction"`
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Reason     string    `json:"reason"`
+	FinalState CallState `json:"final_state"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+	DurationMs int64     `json:"duration_ms"`
+	NodeID     string    `json:"node_id"`
+
+	MessagingProduct string `json:"messaging_product,omitempty"`
+	CallbackData     string `json:"biz_opaque_callback_data,omitempty"`
+
+	// Set when --call-duration-distribution planned the call's length
+	DurationDistribution string `json:"duration_distribution,omitempty"`
+	PlannedDurationMs    int64  `json:"planned_duration_ms,omitempty"`
+
+	// Read just before close when --close-stats-delay is set
+	FinalStats *FinalMediaStats `json:"final_stats,omitempty"`
+}
+
+// FinalMediaStats is the call's transport totals from GetStats together
+// with the loss last reported by the remote in RTCP
+type FinalMediaStats struct {
+	BytesSent     uint64  `json:"bytes_sent"`
+	BytesReceived uint64  `json:"bytes_received"`
+	PacketsLost   uint32  `json:"packets_lost"`
+	FractionLost  float64 `json:"fraction_lost"`
+	RTTMs         float64 `json:"rtt_ms,omitempty"`
+}
+
+func collectFinalStats(details *CallIDDetails) *FinalMediaStats {
+	quality := details.Quality()
+	final := FinalMediaStats{
+		PacketsLost:  quality.TotalLost,
+		FractionLost: quality.FractionLost,
+		RTTMs:        quality.RTTMs,
+	}
+	for _, stats := range details.pc.GetStats() {
+		switch stats := stats.(type) {
+		case webrtc.TransportStats:
+			final.BytesSent += stats.BytesSent
+			final.BytesReceived += stats.BytesReceived
+		case webrtc.ICECandidatePairStats:
+			// Fall back to the STUN RTT when no Receiver Report carried one
+			if stats.Nominated && final.RTTMs == 0 {
+				final.RTTMs = stats.CurrentRoundTripTime * 1000
+			}
+		}
+	}
+	return &final
+}
+
+func newCallDetailRecord(callID string, details *CallIDDetails, finalState CallState, reason string) CallDetailRecord {
+	endedAt := time.Now()
+	record := CallDetailRecord{
+		CallID:     callID,
+		Direction:  details.direction,
+		From:       details.from,
+		To:         details.to,
+		Outcome:    classifyOutcome(finalState, reason),
+		Reason:     reason,
+		FinalState: finalState,
+		StartedAt:  details.createdAt,
+		EndedAt:    endedAt,
+		DurationMs: endedAt.Sub(details.createdAt).Milliseconds(),
+		NodeID:     config.NodeID,
+
+		MessagingProduct: details.messagingProduct,
+		CallbackData:     details.callbackData,
+	}
+	if planned := details.PlannedDuration(); planned > 0 {
+		record.DurationDistribution = config.CallDuration.String()
+		record.PlannedDurationMs = planned.Milliseconds()
+	}
+	return record
+}
+
+func classifyOutcome(finalState CallState, reason string) string {
+	switch {
+	case finalState == CallStateHalfOpen || reason == ReasonHalfOpen:
+		return OutcomeHalfOpen
+	case reason == ReasonReject:
+		return OutcomeRejected
+	case finalState == CallStateOffered:
+		return OutcomeNoAnswer
+	}
+	return OutcomeCompleted
+}
+
+func writeCDR(record CallDetailRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error marshaling CDR for %s: %v\n", record.CallID, err)
+		return
+	}
+	log.Printf("CDR %s\n", data)
+}
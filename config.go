@@ -0,0 +1,565 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ResponseModeEvent   = "event"
+	ResponseModeMinimal = "minimal"
+)
+
+// Config holds the server-wide settings populated from command-line flags.
+type Config struct {
+	Port            string
+	ResponseMode    string
+	HalfOpenTimeout time.Duration
+	AnswerWaitMax   time.Duration
+	ConnectTimeout  time.Duration
+	MaxWriteErrors  int
+	AcceptJitter    time.Duration
+	CallDuration    durationDistribution
+
+	MaxConcurrentAccepts int
+	AcceptQueueTimeout   time.Duration
+
+	AnswerGatherDelay time.Duration
+	AnswerTimeout     time.Duration
+
+	WaitForConnectTimeout time.Duration
+
+	MaxScenarios   int
+	StatsInterval  time.Duration
+	LeakCheckDelay time.Duration
+	TeardownWait   time.Duration
+
+	ProbeInterval time.Duration
+	ProbeSLA      time.Duration
+
+	StatsSampleInterval time.Duration
+	StatsSampleMax      int
+
+	ReportFile    string
+	ShutdownGrace time.Duration
+
+	StatsDAddr   string
+	StatsDPrefix string
+
+	MaxCalls           int
+	RegistryFullPolicy string
+	NoAutoRemove       bool
+	CallTimeout        time.Duration
+	CapacityHeaders    bool
+
+	ToPool              stringList
+	FromPool            stringList
+	MissingNumberPolicy string
+
+	AudioFile       string
+	AudioDir        string
+	AnswerAudioPool stringList
+	EarlyMediaFile  string
+	MaxMediaBytes   int64
+	MaxOggPageBytes int
+	MediaMix        mediaMix
+
+	MediaDistribution mediaDistribution
+
+	AutoResolve      autoResolveMix
+	AutoResolveDelay durationDistribution
+
+	LogLevel string
+
+	HostOnly        bool
+	DSCP            int
+	StripSDPAttrs   stringList
+	RejectSelfCalls bool
+
+	DTLSCertPoolSize int
+	MediaChecksum    bool
+	CheckUlimit      string
+
+	ICETCP           bool
+	ICETCPPort       int
+	ICETransportPref string
+
+	ICEConfigFile string
+	STUNServers   stringList
+	TURNServers   turnServers
+
+	MessagingProduct string
+	StrictProduct    bool
+	AllowedProducts  stringList
+	ValidateAnswer   bool
+
+	WebhookObject  string
+	AllowedObjects stringList
+	StrictAPI      bool
+
+	AnswerRejectRate float64
+
+	ExpiredAcceptStatus int
+
+	ExposeICECredentials bool
+	PreferInterface      string
+	GlareRole            string
+	APICompat            string
+
+	GracefulTrackClose bool
+	TrackCloseGrace    time.Duration
+	CloseStatsDelay    time.Duration
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	CallbackURLs          weightedURLs
+	CallbackDelay         time.Duration
+	CallbackDelayJitter   time.Duration
+	CallbackMaxAttempts   int
+	CallbackRetryBase     time.Duration
+	CallbackRetryAfterMax time.Duration
+	CallbackSecret        string
+	EmitProgressEvents    bool
+
+	SignalingLatency signalingLatency
+
+	NodeID string
+}
+
+// config is the startup configuration. It is not written once the server
+// runs, so fields that can't be reloaded may be read from it directly.
+var config Config
+
+// liveConfig is the running configuration: config with every reload
+// applied (see ReloadableConfig). Reloads swap in a new copy.
+var liveConfig atomic.Pointer[Config]
+
+// configMu serializes reloads so concurrent patches don't drop each other
+var configMu sync.Mutex
+
+// currentConfig returns a consistent snapshot for request-path code
+func currentConfig() Config {
+	if cfg := liveConfig.Load(); cfg != nil {
+		return *cfg
+	}
+	return config
+}
+
+// defaultNodeID is the hostname, so each node of a fleet is told apart
+// without configuration
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return host
+}
+
+func registerFlags() {
+	flag.StringVar(&config.Port, "p", "8080", "Port to run the server on")
+	flag.StringVar(&config.NodeID, "node-id", defaultNodeID(), "Identifies this load node in log lines, callbacks, CDRs and /stats (default the hostname)")
+	flag.StringVar(&config.ResponseMode, "response-mode", ResponseModeEvent, "Default /load/offer response shape: event or minimal")
+	flag.DurationVar(&config.HalfOpenTimeout, "half-open-timeout", 0, "Tear down offers whose ICE connected but were never accepted after this long (0 waits for the call timeout)")
+	flag.DurationVar(&config.AnswerWaitMax, "answer-wait-max", 0, "Tear down offers that are not accepted within this long (0 waits for the call timeout)")
+	flag.DurationVar(&config.ConnectTimeout, "connect-timeout", 0, "Tear down calls that do not reach ICE-connected within this long after media starts (0 disables)")
+	flag.IntVar(&config.MaxWriteErrors, "max-write-errors", 0, "Consecutive audio write errors tolerated before a call's media stops")
+	flag.DurationVar(&config.AnswerGatherDelay, "answer-gather-delay", 0, "Hold each /load/calls answer this long after ICE gathering completes, like a slow callee (at most 1m; gather_delay_ms overrides it per request)")
+	flag.DurationVar(&config.AnswerTimeout, "answer-timeout", 0, "Give up on a /load/calls answer with 504, closing its PeerConnection, when gathering plus any gather delay takes longer than this (0 waits indefinitely)")
+	flag.DurationVar(&config.AcceptJitter, "accept-jitter", 0, "Delay processing of each accept by a random amount up to this long")
+	flag.IntVar(&config.MaxConcurrentAccepts, "max-concurrent-accepts", 0, "Most accepts negotiating (SetRemoteDescription through ICE connect) at once (0 is unlimited)")
+	flag.DurationVar(&config.AcceptQueueTimeout, "accept-queue-timeout", time.Second, "How long an accept waits for a --max-concurrent-accepts slot before a retryable 503")
+	flag.DurationVar(&config.WaitForConnectTimeout, "wait-for-connect-timeout", 30*time.Second, "Longest /load/offer holds its response for wait_for_connect")
+	flag.Var(&config.CallDuration, "call-duration-distribution", "Length of accepted calls instead of the flat call timeout: fixed:30s, uniform:10s,60s or exponential:90s")
+	flag.IntVar(&config.MaxCalls, "max-calls", 0, "Most calls registered at once (0 is unlimited); see --registry-full-policy")
+	flag.StringVar(&config.RegistryFullPolicy, "registry-full-policy", RegistryFullReject, "What a new call does at --max-calls: reject (429) or evict-oldest (tear down the oldest of the lowest-priority calls, never one of higher priority than the new call)")
+	flag.BoolVar(&config.CapacityHeaders, "capacity-headers", false, "Add X-Active-Calls, X-Max-Calls and X-Load-Factor (active over --max-calls) headers to offer and answer responses")
+	flag.BoolVar(&config.NoAutoRemove, "no-auto-remove", false, "Never reap calls after --call-timeout; they persist until terminated or the server shuts down")
+	flag.DurationVar(&config.CallTimeout, "call-timeout", defaultAutoRemoveTimeout, "How long a call lives before it is reaped, unless the request sets ttl_seconds or timeout_seconds (at most 1h)")
+	flag.IntVar(&config.MaxScenarios, "max-scenarios", 20, "Maximum distinct scenario labels in metrics; further scenarios are reported as \"other\"")
+	flag.BoolVar(&config.HostOnly, "host-only", false, "Gather host candidates only (no mDNS, STUN or TURN) for same-network load tests")
+	flag.IntVar(&config.DTLSCertPoolSize, "dtls-cert-pool-size", 0, "Generate this many DTLS certificates at startup and reuse them round-robin across PeerConnections instead of generating one per call (0 disables)")
+	flag.BoolVar(&config.ICETCP, "ice-tcp", false, "Also gather passive ICE-TCP host candidates, accepted on --ice-tcp-port, for networks that block UDP")
+	flag.IntVar(&config.ICETCPPort, "ice-tcp-port", 0, "TCP port ICE-TCP listens on with --ice-tcp (0 picks a free port)")
+	flag.StringVar(&config.ICETransportPref, "ice-transport-pref", ICETransportUDP, "With --ice-tcp: udp (leave priorities alone), tcp (signal TCP candidates first with UDP ranked lower, which steers pairs the remote nominates) or tcp-only (gather no UDP candidates)")
+	flag.StringVar(&config.ICEConfigFile, "ice-config", "", "JSON file of STUN/TURN servers for every PeerConnection, as {\"ice_servers\": [{\"urls\": [...], \"username\": ..., \"credential\": ...}]}")
+	flag.Var(&config.STUNServers, "stun", "Comma-separated STUN server URLs (e.g. stun:stun.example.com:3478), added after --ice-config; repeatable")
+	flag.Var(&config.TURNServers, "turn", "TURN server as url,username,credential (e.g. turn:turn.example.com:3478?transport=udp,load,secret); repeatable")
+	flag.StringVar(&config.CheckUlimit, "check-ulimit", UlimitCheckOff, "Compare the open file limit with what --max-calls calls need at startup: off, warn (log and start) or fail (refuse to start)")
+	flag.BoolVar(&config.MediaChecksum, "media-checksum", false, "Check every sample written to a call against the CRC-32 taken when its file was read, reported as media_integrity on /stats/:call_id")
+	flag.IntVar(&config.DSCP, "dscp", -1, "DSCP value (0-63, e.g. 46 for EF) marked on outgoing media packets (-1 leaves the OS default)")
+	flag.StringVar(&config.PreferInterface, "prefer-interface", "", "Network interface whose candidates are listed first in signaled SDP, with other candidates ranked lower")
+	flag.BoolVar(&config.ExposeICECredentials, "expose-ice-credentials", false, "Log each call's local ICE ufrag/pwd and include them in /stats/:call_id (debugging only)")
+	flag.StringVar(&config.LogLevel, "log-level", LogLevelInfo, "Log verbosity: info or debug (debug also logs every offer and answer SDP)")
+	flag.StringVar(&config.APICompat, "api-compat", "current", "JSON field naming of the target API version: current or legacy (type for sdp_type, product for messaging_product)")
+	flag.StringVar(&config.GlareRole, "glare-role", "", "Resolve an inbound offer for a call_id we are still offering: polite (roll back and answer) or impolite (refuse); empty disables glare handling")
+	flag.BoolVar(&config.GracefulTrackClose, "graceful-track-close", false, "Send RTCP BYE and stop the audio sender before closing a PeerConnection")
+	flag.DurationVar(&config.TrackCloseGrace, "track-close-grace", 200*time.Millisecond, "How long to wait after RTCP BYE before closing the PeerConnection")
+	flag.DurationVar(&config.CloseStatsDelay, "close-stats-delay", 0, "Wait this long after a call ends to read final GetStats into its CDR before closing the PeerConnection (0 closes immediately)")
+	flag.Var(&config.StripSDPAttrs, "strip-sdp-attrs", "Comma-separated SDP attribute names (e.g. extmap,rtcp-fb) removed from signaled offers and answers")
+	flag.Float64Var(&config.AnswerRejectRate, "answer-reject-rate", 0, "Fraction (0-1) of inbound connects on /load/calls rejected as a busy callee instead of answered")
+	flag.IntVar(&config.ExpiredAcceptStatus, "expired-accept-status", fiber.StatusConflict, "HTTP status for an accept that lands after its call started tearing down (0 answers 200 with the legacy already-closed body)")
+	flag.BoolVar(&config.ValidateAnswer, "validate-answer", false, "Reject accepts whose answer does not match our offer's media sections, codecs or DTLS fingerprint with 400")
+	flag.StringVar(&config.MessagingProduct, "messaging-product", "random", "messaging_product emitted in callbacks when a request does not set one")
+	flag.StringVar(&config.WebhookObject, "webhook-object", DefaultWebhookObject, "object emitted in the webhook envelope of callbacks")
+	flag.Var(&config.AllowedObjects, "allowed-objects", "Comma-separated object values accepted with --strict-api (default whatsapp_business_account)")
+	flag.BoolVar(&config.StrictAPI, "strict-api", false, "Refuse to start unless --webhook-object is in --allowed-objects and --messaging-product is in --allowed-products")
+	flag.BoolVar(&config.StrictProduct, "strict-product", false, "Reject offers and answers whose messaging_product is not in --allowed-products with 400")
+	flag.Var(&config.AllowedProducts, "allowed-products", "Comma-separated messaging_product values accepted with --strict-product (default whatsapp)")
+	flag.BoolVar(&config.RejectSelfCalls, "reject-self-calls", false, "Reject offers whose from and to numbers are the same")
+	flag.DurationVar(&config.ProbeInterval, "probe-interval", 0, "Run a loopback canary call (offer, accept, connect, teardown) this often and report it on /stats (0 disables)")
+	flag.DurationVar(&config.ProbeSLA, "probe-sla", 2*time.Second, "Log an SLA breach when a probe call takes longer than this from offer to ICE-connected")
+	flag.DurationVar(&config.ShutdownGrace, "shutdown-grace", 25*time.Second, "On SIGTERM, refuse new calls and wait this long for active calls to end before tearing them down (SIGINT tears down at once)")
+	flag.StringVar(&config.ReportFile, "report-file", "", "Write the load run report (also on GET /report) to this JSON file at shutdown")
+	flag.StringVar(&config.StatsDAddr, "statsd-addr", "", "host:port of a StatsD server to send call counters, timers and the active-call gauge to (empty disables)")
+	flag.StringVar(&config.StatsDPrefix, "statsd-prefix", "wa_load", "Prefix for StatsD metric names")
+	flag.DurationVar(&config.StatsSampleInterval, "stats-sample-interval", 0, "Sample each connected call's transport counters and RTCP quality this often into a series shown on /stats/:call_id (0 disables)")
+	flag.IntVar(&config.StatsSampleMax, "stats-sample-max", 120, "Most samples kept per call; older ones are dropped")
+	flag.DurationVar(&config.StatsInterval, "stats-interval", 0, "Log a one-line stats summary this often (0 disables)")
+	flag.DurationVar(&config.TeardownWait, "teardown-wait", 500*time.Millisecond, "Longest teardown waits for a call's goroutines to stop before closing its PeerConnection (0 closes at once)")
+	flag.DurationVar(&config.LeakCheckDelay, "leak-check-delay", 2*time.Second, "Report a goroutine leak if a call still has goroutines running this long after teardown (0 disables)")
+	flag.Var(&config.ToPool, "to-pool", "Comma-separated business numbers used round-robin when an inbound call omits to")
+	flag.Var(&config.FromPool, "from-pool", "Comma-separated caller numbers used round-robin when an offer omits from under --missing-number-policy=pool")
+	flag.StringVar(&config.MissingNumberPolicy, "missing-number-policy", MissingNumberPassthrough, "What an offer missing from or to does: passthrough (signal the blanks), reject (400) or pool (fill from --from-pool and --to-pool)")
+	flag.StringVar(&config.AudioFile, "audio-file", fallbackAudioFile, "Ogg/Opus file or http(s) URL streamed on every call; a 48kHz mono 16-bit .wav or .pcm file plays on pcmu calls, and Opus calls fall back to "+fallbackAudioFile+" without an Opus encoder")
+	flag.StringVar(&config.AudioDir, "audio-dir", "", "Directory of Ogg/Opus (or .wav/.pcm) files requests may pick with audio_file (unset rejects audio_file)")
+	flag.Var(&config.AnswerAudioPool, "answer-audio-pool", "Comma-separated Ogg/Opus files or URLs streamed round-robin on inbound calls instead of --audio-file")
+	flag.StringVar(&config.EarlyMediaFile, "early-media-file", "", "Ogg/Opus file looped as ringback once a pre_accept action delivers the answer, until the accept arrives (empty ignores pre_accept)")
+	flag.IntVar(&config.MaxOggPageBytes, "max-ogg-page-bytes", 16<<10, "Reject audio files containing an Ogg page larger than this")
+	flag.Var(&config.AutoResolve, "auto-resolve", "Resolve offers without an external action by weighted outcome, e.g. accept:60,reject:20,noanswer:20")
+	flag.Var(&config.AutoResolveDelay, "auto-resolve-delay", "How long a simulated callee takes to resolve an offer: fixed:2s, uniform:1s,5s or exponential:3s (unset resolves at once)")
+	flag.Var(&config.MediaMix, "media-mix", "Weighted media types for offers that do not set media, e.g. opus:70,video:20,pcmu:10")
+	flag.Var(&config.MediaDistribution, "media-distribution", "Weighted audio files or URLs streamed on calls that name none, e.g. greeting.ogg:50,hold.ogg:30,silence.ogg:20 (replaces --audio-file)")
+	flag.Int64Var(&config.MaxMediaBytes, "max-media-bytes", 10<<20, "Largest media download accepted from an http(s) audio source")
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", 10, "Consecutive PeerConnection setup failures that open the circuit breaker and shed offers and answers with 503 (0 disables)")
+	flag.DurationVar(&config.BreakerCooldown, "breaker-cooldown", 5*time.Second, "How long the open circuit breaker sheds load before probing again")
+	flag.Var(&config.CallbackURLs, "callback-urls", "Comma-separated callback URLs, each optionally prefixed with weight: (e.g. 3:http://a/cb,http://b/cb), picked by weight when a request omits callback_url")
+	flag.DurationVar(&config.CallbackDelay, "callback-delay", 0, "Delay every callback by this long before sending")
+	flag.DurationVar(&config.CallbackDelayJitter, "callback-delay-jitter", 0, "Add a random extra delay of up to this long to every callback")
+	flag.Var(&config.SignalingLatency, "signaling-latency", "Simulated network latency on the offer path: one duration for both a successful /load/offer response and the offer callback, or response,callback (e.g. 50ms,400ms)")
+	flag.IntVar(&config.CallbackMaxAttempts, "callback-max-attempts", 3, "Maximum delivery attempts per callback; connection errors, 5xx and 429/503 with Retry-After are retried")
+	flag.DurationVar(&config.CallbackRetryBase, "callback-retry-base", 200*time.Millisecond, "First callback retry backoff, doubled for each later attempt with jitter (Retry-After takes precedence)")
+	flag.DurationVar(&config.CallbackRetryAfterMax, "callback-retry-after-max", 30*time.Second, "Longest Retry-After delay honored before retrying a callback")
+	flag.StringVar(&config.CallbackSecret, "callback-secret", "", "Sign callbacks with an X-Hub-Signature-256: sha256=<hex> header, the HMAC-SHA256 of the body keyed with this secret")
+	flag.BoolVar(&config.EmitProgressEvents, "emit-progress-events", false, "Follow the connect callback of an offer with ringing and, once ICE connects, connected callbacks")
+}
+
+func (c Config) validate() error {
+	if strings.TrimSpace(c.NodeID) == "" {
+		return fmt.Errorf("node-id must not be empty")
+	}
+	if err := validateResponseMode(c.ResponseMode); err != nil {
+		return err
+	}
+	if err := validateGlareRole(c.GlareRole); err != nil {
+		return err
+	}
+	if err := validateAPICompat(c.APICompat); err != nil {
+		return err
+	}
+	if err := validateLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if err := validateRegistryFullPolicy(c.RegistryFullPolicy); err != nil {
+		return err
+	}
+	if err := validateMissingNumberPolicy(c.MissingNumberPolicy); err != nil {
+		return err
+	}
+	if err := validateUlimitCheck(c.CheckUlimit); err != nil {
+		return err
+	}
+	if err := validateICETransportPref(c.ICETransportPref, c.ICETCP); err != nil {
+		return err
+	}
+	if c.ICETCPPort < 0 || c.ICETCPPort > 65535 {
+		return fmt.Errorf("ice-tcp-port must be between 0 and 65535")
+	}
+	if err := c.validateStrictAPI(); err != nil {
+		return err
+	}
+	if c.MaxCalls < 0 {
+		return fmt.Errorf("max-calls must not be negative")
+	}
+	if c.HalfOpenTimeout < 0 {
+		return fmt.Errorf("half-open-timeout must not be negative")
+	}
+	if c.AnswerWaitMax < 0 {
+		return fmt.Errorf("answer-wait-max must not be negative")
+	}
+	if c.ConnectTimeout < 0 {
+		return fmt.Errorf("connect-timeout must not be negative")
+	}
+	if c.MaxWriteErrors < 0 {
+		return fmt.Errorf("max-write-errors must not be negative")
+	}
+	if c.AnswerGatherDelay < 0 || c.AnswerGatherDelay > maxAnswerGatherDelay {
+		return fmt.Errorf("answer-gather-delay must be between 0 and %s", maxAnswerGatherDelay)
+	}
+	if c.ExpiredAcceptStatus != 0 && (c.ExpiredAcceptStatus < 400 || c.ExpiredAcceptStatus > 599) {
+		return fmt.Errorf("expired-accept-status must be a 4xx or 5xx status, or 0")
+	}
+	if c.CallTimeout <= 0 || c.CallTimeout > maxCallTimeout {
+		return fmt.Errorf("call-timeout must be positive and at most %s", maxCallTimeout)
+	}
+	if c.AnswerTimeout < 0 {
+		return fmt.Errorf("answer-timeout must not be negative")
+	}
+	if c.AcceptJitter < 0 {
+		return fmt.Errorf("accept-jitter must not be negative")
+	}
+	if c.MaxConcurrentAccepts < 0 {
+		return fmt.Errorf("max-concurrent-accepts must not be negative")
+	}
+	if c.AcceptQueueTimeout < 0 {
+		return fmt.Errorf("accept-queue-timeout must not be negative")
+	}
+	if c.WaitForConnectTimeout <= 0 {
+		return fmt.Errorf("wait-for-connect-timeout must be positive")
+	}
+	if c.TrackCloseGrace < 0 {
+		return fmt.Errorf("track-close-grace must not be negative")
+	}
+	if c.DSCP < -1 || c.DSCP > 63 {
+		return fmt.Errorf("dscp must be between 0 and 63, or -1 to disable")
+	}
+	if c.AnswerRejectRate < 0 || c.AnswerRejectRate > 1 {
+		return fmt.Errorf("answer-reject-rate must be between 0 and 1")
+	}
+	if c.DTLSCertPoolSize < 0 {
+		return fmt.Errorf("dtls-cert-pool-size must not be negative")
+	}
+	if c.StatsSampleInterval < 0 {
+		return fmt.Errorf("stats-sample-interval must not be negative")
+	}
+	if c.StatsSampleMax < 1 {
+		return fmt.Errorf("stats-sample-max must be positive")
+	}
+	if c.CloseStatsDelay < 0 {
+		return fmt.Errorf("close-stats-delay must not be negative")
+	}
+	if c.MaxMediaBytes < 1 {
+		return fmt.Errorf("max-media-bytes must be positive")
+	}
+	if c.MaxOggPageBytes < 1 {
+		return fmt.Errorf("max-ogg-page-bytes must be positive")
+	}
+	if c.StatsInterval < 0 {
+		return fmt.Errorf("stats-interval must not be negative")
+	}
+	if c.MaxScenarios < 1 {
+		return fmt.Errorf("max-scenarios must be at least 1")
+	}
+	if c.BreakerThreshold < 0 {
+		return fmt.Errorf("breaker-threshold must not be negative")
+	}
+	if c.BreakerCooldown <= 0 {
+		return fmt.Errorf("breaker-cooldown must be positive")
+	}
+	if c.CallbackDelay < 0 || c.CallbackDelayJitter < 0 {
+		return fmt.Errorf("callback-delay and callback-delay-jitter must not be negative")
+	}
+	if c.CallbackMaxAttempts < 1 {
+		return fmt.Errorf("callback-max-attempts must be at least 1")
+	}
+	if c.CallbackRetryBase <= 0 {
+		return fmt.Errorf("callback-retry-base must be positive")
+	}
+	if c.CallbackRetryAfterMax < 0 {
+		return fmt.Errorf("callback-retry-after-max must not be negative")
+	}
+	return nil
+}
+
+func validateResponseMode(mode string) error {
+	switch mode {
+	case ResponseModeEvent, ResponseModeMinimal:
+		return nil
+	}
+	return fmt.Errorf("invalid response mode %q (want %s or %s)", mode, ResponseModeEvent, ResponseModeMinimal)
+}
+
+// stringList is a flag.Value for comma-separated lists; repeating the flag appends
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// ReloadableConfig is the JSON view of the settings POST /load/reload may
+// change while calls are in flight. In a patch, omitted fields are left as
+// they are. Durations use Go syntax, e.g. "30s".
+type ReloadableConfig struct {
+	ResponseMode          *string `json:"response_mode,omitempty"`
+	LogLevel              *string `json:"log_level,omitempty"`
+	AudioFile             *string `json:"audio_file,omitempty"`
+	MaxMediaBytes         *int64  `json:"max_media_bytes,omitempty"`
+	RejectSelfCalls       *bool   `json:"reject_self_calls,omitempty"`
+	CallTimeout           *string `json:"call_timeout,omitempty"`
+	NoAutoRemove          *bool   `json:"no_auto_remove,omitempty"`
+	MaxCalls              *int    `json:"max_calls,omitempty"`
+	MaxConcurrentAccepts  *int    `json:"max_concurrent_accepts,omitempty"`
+	AcceptQueueTimeout    *string `json:"accept_queue_timeout,omitempty"`
+	HalfOpenTimeout       *string `json:"half_open_timeout,omitempty"`
+	AnswerWaitMax         *string `json:"answer_wait_max,omitempty"`
+	ConnectTimeout        *string `json:"connect_timeout,omitempty"`
+	AcceptJitter          *string `json:"accept_jitter,omitempty"`
+	GracefulTrackClose    *bool   `json:"graceful_track_close,omitempty"`
+	TrackCloseGrace       *string `json:"track_close_grace,omitempty"`
+	CallbackDelay         *string `json:"callback_delay,omitempty"`
+	CallbackDelayJitter   *string `json:"callback_delay_jitter,omitempty"`
+	CallbackMaxAttempts   *int    `json:"callback_max_attempts,omitempty"`
+	CallbackRetryAfterMax *string `json:"callback_retry_after_max,omitempty"`
+}
+
+type durationField struct {
+	patch  *string
+	target *time.Duration
+}
+
+func (p ReloadableConfig) durations(c *Config) map[string]durationField {
+	return map[string]durationField{
+		"call_timeout":             {p.CallTimeout, &c.CallTimeout},
+		"accept_queue_timeout":     {p.AcceptQueueTimeout, &c.AcceptQueueTimeout},
+		"half_open_timeout":        {p.HalfOpenTimeout, &c.HalfOpenTimeout},
+		"answer_wait_max":          {p.AnswerWaitMax, &c.AnswerWaitMax},
+		"connect_timeout":          {p.ConnectTimeout, &c.ConnectTimeout},
+		"accept_jitter":            {p.AcceptJitter, &c.AcceptJitter},
+		"track_close_grace":        {p.TrackCloseGrace, &c.TrackCloseGrace},
+		"callback_delay":           {p.CallbackDelay, &c.CallbackDelay},
+		"callback_delay_jitter":    {p.CallbackDelayJitter, &c.CallbackDelayJitter},
+		"callback_retry_after_max": {p.CallbackRetryAfterMax, &c.CallbackRetryAfterMax},
+	}
+}
+
+// apply writes the patch onto c; c is left partially updated on error
+func (p ReloadableConfig) apply(c *Config) error {
+	if p.ResponseMode != nil {
+		c.ResponseMode = *p.ResponseMode
+	}
+	if p.LogLevel != nil {
+		c.LogLevel = *p.LogLevel
+	}
+	if p.AudioFile != nil {
+		c.AudioFile = *p.AudioFile
+	}
+	if p.MaxMediaBytes != nil {
+		c.MaxMediaBytes = *p.MaxMediaBytes
+	}
+	if p.RejectSelfCalls != nil {
+		c.RejectSelfCalls = *p.RejectSelfCalls
+	}
+	if p.NoAutoRemove != nil {
+		c.NoAutoRemove = *p.NoAutoRemove
+	}
+	if p.MaxCalls != nil {
+		c.MaxCalls = *p.MaxCalls
+	}
+	if p.MaxConcurrentAccepts != nil {
+		c.MaxConcurrentAccepts = *p.MaxConcurrentAccepts
+	}
+	if p.GracefulTrackClose != nil {
+		c.GracefulTrackClose = *p.GracefulTrackClose
+	}
+	if p.CallbackMaxAttempts != nil {
+		c.CallbackMaxAttempts = *p.CallbackMaxAttempts
+	}
+	for name, field := range p.durations(c) {
+		if field.patch == nil {
+			continue
+		}
+		d, err := time.ParseDuration(*field.patch)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field.target = d
+	}
+	return nil
+}
+
+func newReloadableConfig(c Config) ReloadableConfig {
+	view := ReloadableConfig{
+		ResponseMode:         &c.ResponseMode,
+		LogLevel:             &c.LogLevel,
+		AudioFile:            &c.AudioFile,
+		MaxMediaBytes:        &c.MaxMediaBytes,
+		RejectSelfCalls:      &c.RejectSelfCalls,
+		NoAutoRemove:         &c.NoAutoRemove,
+		MaxCalls:             &c.MaxCalls,
+		MaxConcurrentAccepts: &c.MaxConcurrentAccepts,
+		GracefulTrackClose:   &c.GracefulTrackClose,
+		CallbackMaxAttempts:  &c.CallbackMaxAttempts,
+	}
+	format := func(d time.Duration) *string {
+		s := d.String()
+		return &s
+	}
+	view.CallTimeout = format(c.CallTimeout)
+	view.AcceptQueueTimeout = format(c.AcceptQueueTimeout)
+	view.HalfOpenTimeout = format(c.HalfOpenTimeout)
+	view.AnswerWaitMax = format(c.AnswerWaitMax)
+	view.ConnectTimeout = format(c.ConnectTimeout)
+	view.AcceptJitter = format(c.AcceptJitter)
+	view.TrackCloseGrace = format(c.TrackCloseGrace)
+	view.CallbackDelay = format(c.CallbackDelay)
+	view.CallbackDelayJitter = format(c.CallbackDelayJitter)
+	view.CallbackRetryAfterMax = format(c.CallbackRetryAfterMax)
+	return view
+}
+
+// reloadConfig applies a ReloadableConfig patch atomically: either every
+// field in the patch takes effect or none does. The listen port and other
+// startup-only settings cannot be changed here. Calls already set up keep
+// the call timeout they started with.
+func reloadConfig(c *fiber.Ctx) error {
+	var patch ReloadableConfig
+	if err := parseBody(c, &patch); err != nil {
+		return send(c, fiber.StatusBadRequest, fiber.Map{"error": "Invalid request body"})
+	}
+
+	// Make sure new media loads before any call can pick it up
+	if patch.AudioFile != nil {
+		if _, err := loadPrecompiledMedia(*patch.AudioFile); err != nil {
+			return send(c, fiber.StatusBadRequest, fiber.Map{"error": fmt.Sprintf("audio_file: %v", err)})
+		}
+	}
+
+	configMu.Lock()
+	previous := currentConfig()
+	updated := previous
+	err := patch.apply(&updated)
+	if err == nil {
+		err = updated.validate()
+	}
+	if err == nil {
+		if updated.MaxConcurrentAccepts != previous.MaxConcurrentAccepts {
+			setAcceptSlots(updated.MaxConcurrentAccepts)
+		}
+		liveConfig.Store(&updated)
+	}
+	configMu.Unlock()
+
+	if err != nil {
+		return send(c, fiber.StatusBadRequest, fiber.Map{"error": err.Error()})
+	}
+
+	log.Printf("🔄 Configuration reloaded\n")
+	return send(c, fiber.StatusOK, newReloadableConfig(updated))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Run with -race: reloads must not race the config reads of calls being
+// set up and torn down at the same time
+func TestReloadDuringOffers(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	stop := make(chan struct{})
+	var reloads sync.WaitGroup
+	reloads.Add(1)
+	go func() {
+		defer reloads.Done()
+		modes := []string{ResponseModeEvent, ResponseModeMinimal}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			resp, _ := doJSON(t, app, fiber.MethodPost, "/load/reload", map[string]any{
+				"response_mode":  modes[i%2],
+				"accept_jitter":  (time.Duration(i%3) * time.Millisecond).String(),
+				"callback_delay": "0s",
+			})
+			if resp.StatusCode != fiber.StatusOK {
+				t.Errorf("reload = %d, want 200", resp.StatusCode)
+				return
+			}
+		}
+	}()
+
+	var offers sync.WaitGroup
+	for range 8 {
+		offers.Add(1)
+		go func() {
+			defer offers.Done()
+			for range 3 {
+				resp, callID := offer(t, app, nil)
+				if resp.StatusCode != fiber.StatusOK {
+					t.Errorf("offer = %d, want 200", resp.StatusCode)
+					return
+				}
+				teardownCall(callID, ReasonTerminate)
+			}
+		}()
+	}
+	offers.Wait()
+	close(stop)
+	reloads.Wait()
+
+	resp, _ := doJSON(t, app, fiber.MethodPost, "/load/reload", map[string]any{"response_mode": ResponseModeMinimal})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("reload = %d, want 200", resp.StatusCode)
+	}
+	if got := currentConfig().ResponseMode; got != ResponseModeMinimal {
+		t.Errorf("response mode after reload = %q, want %q", got, ResponseModeMinimal)
+	}
+	if config.ResponseMode != defaultConfig.ResponseMode {
+		t.Errorf("reload wrote the startup config")
+	}
+}
+
+func TestReloadRejectsInvalidPatch(t *testing.T) {
+	app := newTestApp(t, nil)
+	before := currentConfig()
+
+	resp, _ := doJSON(t, app, fiber.MethodPost, "/load/reload", map[string]any{"accept_jitter": "soon"})
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("reload = %d, want 400", resp.StatusCode)
+	}
+	if currentConfig().AcceptJitter != before.AcceptJitter {
+		t.Errorf("a rejected reload changed the config")
+	}
+}
+
+// The call timeout, limits and log level take effect for calls set up
+// after the reload, and the patch may be MessagePack like any request
+func TestReloadLiveSettings(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	patch, err := msgpack.Marshal(map[string]any{
+		"call_timeout":           "2s",
+		"log_level":              LogLevelDebug,
+		"max_calls":              1,
+		"max_concurrent_accepts": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(fiber.MethodPost, "/load/reload", bytes.NewReader(patch))
+	req.Header.Set(fiber.HeaderContentType, MIMEApplicationMsgpack)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("msgpack reload = %d, want 200", resp.StatusCode)
+	}
+
+	cfg := currentConfig()
+	if cfg.CallTimeout != 2*time.Second || cfg.LogLevel != LogLevelDebug || cfg.MaxCalls != 1 || cfg.MaxConcurrentAccepts != 1 {
+		t.Fatalf("config after reload = %s %s %d %d", cfg.CallTimeout, cfg.LogLevel, cfg.MaxCalls, cfg.MaxConcurrentAccepts)
+	}
+	if slots := *acceptSlots.Load(); cap(slots) != 1 {
+		t.Errorf("%d accept slots, want 1", cap(slots))
+	}
+
+	resp, callID := offer(t, app, nil)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("offer = %d, want 200", resp.StatusCode)
+	}
+	val, _ := ActionChannels.Load(callID)
+	if timeout := val.(*CallIDDetails).autoRemoveTimeout; timeout != 2*time.Second {
+		t.Errorf("call timeout = %s, want 2s", timeout)
+	}
+	if resp, _ := offer(t, app, nil); resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("offer past max_calls = %d, want 429", resp.StatusCode)
+	}
+
+	resp, _ = doJSON(t, app, fiber.MethodPost, "/load/reload", map[string]any{"max_calls": 0, "no_auto_remove": true, "max_concurrent_accepts": 0})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("reload = %d, want 200", resp.StatusCode)
+	}
+	if slots := *acceptSlots.Load(); slots != nil {
+		t.Errorf("accept slots still limited to %d", cap(slots))
+	}
+	resp, callID = offer(t, app, nil)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("offer after lifting max_calls = %d, want 200", resp.StatusCode)
+	}
+	val, _ = ActionChannels.Load(callID)
+	if expires := val.(*CallIDDetails).expiresAt; !expires.IsZero() {
+		t.Errorf("call expires at %s with no_auto_remove", expires)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// diagGatherTimeout bounds a diagnostic gather that never completes, e.g.
+// while a STUN server is unreachable
+const diagGatherTimeout = 15 * time.Second
+
+type GatheredCandidate struct {
+	ElapsedMs int64  `json:"elapsed_ms"`
+	Type      string `json:"type"`
+	Protocol  string `json:"protocol"`
+	Address   string `json:"address"`
+	Port      uint16 `json:"port"`
+	Candidate string `json:"candidate"`
+}
+
+type GatherReport struct {
+	StartedAt  time.Time           `json:"started_at"`
+	DurationMs int64               `json:"duration_ms"`
+	Complete   bool                `json:"complete"`
+	Candidates []GatheredCandidate `json:"candidates"`
+	Counts     map[string]int      `json:"counts"`
+}
+
+// diagGather gathers candidates on a throwaway PeerConnection, configured
+// like a call's, and reports each one with its time since gathering
+// started. No call is created and the circuit breaker is not involved.
+func diagGather(c *fiber.Ctx) error {
+	pc, err := webrtcAPI.NewPeerConnection(peerConfiguration())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer pc.Close()
+
+	// Gathering needs a media section to start
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report := GatherReport{Candidates: []GatheredCandidate{}, Counts: map[string]int{}}
+	var mu sync.Mutex
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		report.Candidates = append(report.Candidates, GatheredCandidate{
+			ElapsedMs: time.Since(report.StartedAt).Milliseconds(),
+			Type:      candidate.Typ.String(),
+			Protocol:  candidate.Protocol.String(),
+			Address:   candidate.Address,
+			Port:      candidate.Port,
+			Candidate: candidate.ToJSON().Candidate,
+		})
+		report.Counts[candidate.Typ.String()]++
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	report.StartedAt = time.Now()
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	select {
+	case <-gatherComplete:
+		report.Complete = true
+	case <-time.After(diagGatherTimeout):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	log.Printf("🔌 Diagnostic gather: %d candidates in %dms (complete: %t) %v\n", len(report.Candidates), report.DurationMs, report.Complete, report.Counts)
+	return c.JSON(report)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// dscpNet is the network ICE uses when --dscp is set. Every UDP socket it
+// opens is marked so the kernel sets the DSCP bits on outgoing media.
+type dscpNet struct {
+	*stdnet.Net
+	dscp int
+}
+
+func newDSCPNet(dscp int) (*dscpNet, error) {
+	n, err := stdnet.NewNet()
+	if err != nil {
+		return nil, err
+	}
+	return &dscpNet{Net: n, dscp: dscp}, nil
+}
+
+func (n *dscpNet) ListenUDP(network string, laddr *net.UDPAddr) (transport.UDPConn, error) {
+	conn, err := n.Net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := setDSCP(conn, network, n.dscp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n *dscpNet) ListenPacket(network string, address string) (net.PacketConn, error) {
+	conn, err := n.Net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := conn.(net.Conn); ok {
+		if err := setDSCP(c, network, n.dscp); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// setDSCP sets the traffic class on conn and reads it back, so platforms
+// that silently ignore the option are reported instead of going unnoticed.
+// A dual-stack "udp" socket only needs one of the two families to succeed.
+func setDSCP(conn net.Conn, network string, dscp int) error {
+	tos := dscp << 2 // DSCP is the upper six bits of the TOS / traffic class byte
+
+	set4 := func() error {
+		c := ipv4.NewConn(conn)
+		if err := c.SetTOS(tos); err != nil {
+			return err
+		}
+		if got, err := c.TOS(); err != nil || got != tos {
+			return fmt.Errorf("IP_TOS not applied (got %d, err %v)", got, err)
+		}
+		return nil
+	}
+	set6 := func() error {
+		c := ipv6.NewConn(conn)
+		if err := c.SetTrafficClass(tos); err != nil {
+			return err
+		}
+		if got, err := c.TrafficClass(); err != nil || got != tos {
+			return fmt.Errorf("IPV6_TCLASS not applied (got %d, err %v)", got, err)
+		}
+		return nil
+	}
+
+	var err error
+	switch network {
+	case "udp4":
+		err = set4()
+	case "udp6":
+		err = set6()
+	default:
+		if err = set6(); err != nil {
+			err = set4()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("setting DSCP %d on %s socket: %w", dscp, network, err)
+	}
+	return nil
+}
+
+// checkDSCPSupport opens a throwaway socket to confirm DSCP marking works
+// on this host before any call depends on it
+func checkDSCPSupport(n *dscpNet) error {
+	conn, err := n.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
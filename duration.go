@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Call length distributions for --call-duration-distribution
+const (
+	DistributionFixed       = "fixed"
+	DistributionUniform     = "uniform"
+	DistributionExponential = "exponential"
+)
+
+// durationDistribution is a flag.Value for call length specs:
+// fixed:30s, uniform:10s,60s or exponential:90s (the mean)
+type durationDistribution struct {
+	kind string
+	a, b time.Duration
+}
+
+func (d *durationDistribution) String() string {
+	switch d.kind {
+	case DistributionUniform:
+		return fmt.Sprintf("%s:%s,%s", d.kind, d.a, d.b)
+	case DistributionFixed, DistributionExponential:
+		return fmt.Sprintf("%s:%s", d.kind, d.a)
+	}
+	return ""
+}
+
+func (d *durationDistribution) Set(value string) error {
+	kind, params, _ := strings.Cut(value, ":")
+	parts := strings.Split(params, ",")
+	durations := make([]time.Duration, len(parts))
+	for i, part := range parts {
+		v, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("call duration %q: %w", value, err)
+		}
+		if v <= 0 {
+			return fmt.Errorf("call duration %q: durations must be positive", value)
+		}
+		durations[i] = v
+	}
+
+	switch kind {
+	case DistributionFixed, DistributionExponential:
+		if len(durations) != 1 {
+			return fmt.Errorf("%s takes a single duration, e.g. %s:30s", kind, kind)
+		}
+		*d = durationDistribution{kind: kind, a: durations[0]}
+	case DistributionUniform:
+		if len(durations) != 2 || durations[0] > durations[1] {
+			return fmt.Errorf("uniform takes min,max, e.g. uniform:10s,60s")
+		}
+		*d = durationDistribution{kind: kind, a: durations[0], b: durations[1]}
+	default:
+		return fmt.Errorf("unknown distribution %q (want %s, %s or %s)", kind, DistributionFixed, DistributionUniform, DistributionExponential)
+	}
+	return nil
+}
+
+func (d durationDistribution) sample() time.Duration {
+	switch d.kind {
+	case DistributionUniform:
+		return d.a + rand.N(d.b-d.a+1)
+	case DistributionExponential:
+		return time.Duration(rand.ExpFloat64() * float64(d.a))
+	}
+	return d.a
+}
+
+// scheduleCallDuration hangs an accepted call up after a length sampled from
+// --call-duration-distribution, in place of the flat call timeout
+func scheduleCallDuration(callID string, details *CallIDDetails) {
+	if config.CallDuration.kind == "" {
+		return
+	}
+	planned := config.CallDuration.sample()
+	details.setPlannedDuration(planned)
+	details.addTimeline(TimelineDurationPlanned, planned.String())
+
+	details.goTracked("call_duration", func() {
+		timer := time.NewTimer(planned)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			teardownCall(callID, ReasonDurationElapsed)
+		case <-details.ctx.Done():
+		}
+	})
+}
+
+func (d *CallIDDetails) setPlannedDuration(planned time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.plannedDuration = planned
+}
+
+func (d *CallIDDetails) PlannedDuration() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.plannedDuration
+}
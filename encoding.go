@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MIMEApplicationMsgpack is negotiated with Accept for compact responses
+// and honored as a request Content-Type. JSON stays the default.
+const MIMEApplicationMsgpack = "application/msgpack"
+
+// send writes v as JSON, or as MessagePack when the client prefers it.
+// MessagePack keys are the JSON field names, omitempty included.
+func send(c *fiber.Ctx, status int, v any) error {
+	if c.Accepts(fiber.MIMEApplicationJSON, MIMEApplicationMsgpack) != MIMEApplicationMsgpack {
+		return c.Status(status).JSON(v)
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, MIMEApplicationMsgpack)
+	return c.Status(status).Send(buf.Bytes())
+}
+
+func isMsgpackBody(c *fiber.Ctx) bool {
+	mediaType, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case MIMEApplicationMsgpack, "application/x-msgpack":
+		return true
+	}
+	return false
+}
+
+// msgpackToJSON re-encodes a MessagePack body as JSON so msgpack requests
+// go through the same parsing (and --api-compat renaming) as JSON ones
+func msgpackToJSON(body []byte) ([]byte, error) {
+	var generic any
+	if err := msgpack.Unmarshal(body, &generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+// parseBody is BodyParser that also accepts MessagePack bodies
+func parseBody(c *fiber.Ctx, v any) error {
+	if !isMsgpackBody(c) {
+		return c.BodyParser(v)
+	}
+	data, err := msgpackToJSON(c.Body())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
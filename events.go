@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Lifecycle event types published on the EventBus
+const (
+	EventCreated         = "created"          // offer or answer created and registered
+	EventRinging         = "ringing"          // offer delivered and the callee alerted
+	EventAccepted        = "accepted"         // offer accepted by the client
+	EventConnected       = "connected"        // ICE connected for the first time
+	EventConnectionState = "connection_state" // PeerConnection state changed
+	EventFailed          = "failed"           // offer or answer could not be created
+	EventTerminated      = "terminated"       // call torn down
+)
+
+// LifecycleEvent is one step in a call's life. Which optional fields are
+// set depends on Type.
+type LifecycleEvent struct {
+	Type      string
+	CallID    string
+	Direction string
+	Scenario  string
+	At        time.Time
+
+	Details *CallIDDetails    // all but EventFailed
+	Payload *Event            // EventCreated: the webhook sent to the callback URL
+	Record  *CallDetailRecord // EventTerminated
+
+	ConnectionState webrtc.PeerConnectionState // EventConnectionState
+}
+
+// EventBus fans lifecycle events out to every subscriber. Publish runs
+// subscribers synchronously, so they must not block.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []func(LifecycleEvent)
+}
+
+var events = &EventBus{}
+
+func (b *EventBus) Subscribe(fn func(LifecycleEvent)) {
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, fn)
+	b.mu.Unlock()
+}
+
+func (b *EventBus) Publish(event LifecycleEvent) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// publishCallEvent publishes a lifecycle event for a registered call
+func publishCallEvent(eventType string, callID string, details *CallIDDetails) {
+	events.Publish(LifecycleEvent{
+		Type:      eventType,
+		CallID:    callID,
+		Direction: details.direction,
+		Scenario:  details.scenario,
+		Details:   details,
+	})
+}
+
+// registerEventSinks subscribes the built-in sinks; call once at startup
+func registerEventSinks() {
+	events.Subscribe(metricsSink)
+	events.Subscribe(cdrSink)
+	events.Subscribe(callbackSink)
+}
+
+func metricsSink(event LifecycleEvent) {
+	switch event.Type {
+	case EventCreated:
+		metrics.observeActive(int64(registeredCalls()))
+		if event.Direction == DirectionBusinessInitiated {
+			metrics.AnswersCreated.Add(1)
+			metrics.scenario(event.Scenario).Answers.Add(1)
+			return
+		}
+		metrics.OffersCreated.Add(1)
+		metrics.recordMedia(event.Details.media)
+		metrics.scenario(event.Scenario).Offers.Add(1)
+	case EventFailed:
+		if event.Direction == DirectionBusinessInitiated {
+			metrics.AnswerFailures.Add(1)
+			return
+		}
+		metrics.OfferFailures.Add(1)
+		metrics.scenario(event.Scenario).OfferFailures.Add(1)
+	case EventConnectionState:
+		metrics.recordConnectionState(event.ConnectionState.String())
+	case EventTerminated:
+		metrics.recordTeardown(event.Scenario, event.Record.Reason)
+		if event.Direction == DirectionBusinessInitiated && event.Record.Reason == ReasonReject {
+			metrics.AnswersRejected.Add(1)
+		}
+	}
+}
+
+func cdrSink(event LifecycleEvent) {
+	if event.Type == EventTerminated {
+		writeCDR(*event.Record)
+	}
+}
+
+// callbackSink delivers webhooks to the call's callback URL, if it has one
+func callbackSink(event LifecycleEvent) {
+	if event.Details == nil || event.Details.callbackURL == "" {
+		return
+	}
+	switch event.Type {
+	case EventCreated:
+		var latency time.Duration
+		if event.Details.direction == DirectionUserInitiated {
+			latency = config.SignalingLatency.callback
+		}
+		sendCallbackAsync(event.Details.ctx, event.Details.callbackURL, *event.Payload, latency)
+	case EventRinging, EventConnected:
+		// Progress callbacks only follow an offer's connect callback
+		if config.EmitProgressEvents && event.Details.direction == DirectionUserInitiated {
+			sendCallbackAsync(event.Details.ctx, event.Details.callbackURL, createProgressPayload(event.CallID, event.Details, event.Type), config.SignalingLatency.callback)
+		}
+	case EventTerminated:
+		// The call's context is already cancelled; the terminate callback is
+		// its final report and must outlive it
+		sendCallbackAsync(context.Background(), event.Details.callbackURL, createTerminatePayload(*event.Record), 0)
+	}
+}
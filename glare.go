@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// How a call resolves glare (an inbound offer arriving for a call_id whose
+// own offer is still outstanding), set with --glare-role
+const (
+	GlareRolePolite   = "polite"   // roll back our offer and answer theirs
+	GlareRoleImpolite = "impolite" // keep our offer and refuse theirs
+)
+
+func validateGlareRole(role string) error {
+	switch role {
+	case "", GlareRolePolite, GlareRoleImpolite:
+		return nil
+	}
+	return fmt.Errorf("invalid glare role %q (want %s or %s)", role, GlareRolePolite, GlareRoleImpolite)
+}
+
+// resolveGlare handles an inbound offer for a call_id that is still
+// registered. A nil error means the inbound offer should be answered as a
+// new call.
+//
+// pion cannot roll back a local offer (have-local-offer -> stable), so the
+// polite side rolls back by tearing down its offering PeerConnection and
+// answering on a fresh one under the same call_id.
+func resolveGlare(callID string, details *CallIDDetails) error {
+	state := details.State()
+	if details.direction != DirectionUserInitiated || (state != CallStateOffered && state != CallStateHalfOpen) {
+		return errors.New("call_id is already in use")
+	}
+	metrics.GlareCollisions.Add(1)
+
+	if config.GlareRole == GlareRoleImpolite {
+		details.addTimeline(TimelineGlare, "kept local offer")
+		log.Printf("%s Glare: keeping our offer, ignoring the remote one\n", callID)
+		return errors.New("glare: our offer is outstanding, remote offer ignored")
+	}
+
+	details.addTimeline(TimelineGlare, "rolled back local offer")
+	log.Printf("%s Glare: rolling back our offer to answer the remote one\n", callID)
+	teardownCall(callID, ReasonGlare)
+	return nil
+}
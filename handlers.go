@@ -0,0 +1,578 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// requestError is a request that failed with a specific HTTP status and
+// JSON body. Anything else returned by a handle* function is a 500.
+type requestError struct {
+	status     int
+	body       fiber.Map
+	retryAfter time.Duration
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprint(e.body["error"])
+}
+
+func newRequestError(status int, message string) *requestError {
+	return &requestError{status: status, body: fiber.Map{"error": message}}
+}
+
+// callError is a requestError that also reports the call_id
+func callError(status int, message, callID string) *requestError {
+	return &requestError{status: status, body: fiber.Map{"error": message, "call_id": callID}}
+}
+
+// shedLoad rejects a request while the circuit breaker is open
+func shedLoad(retryAfter time.Duration) *requestError {
+	metrics.ShedRequests.Add(1)
+	err := newRequestError(fiber.StatusServiceUnavailable, "Overloaded: PeerConnection creation is failing, retry later")
+	err.retryAfter = retryAfter
+	return err
+}
+
+// registryFull rejects a call at --max-calls
+func registryFull(err error) *requestError {
+	reqErr := newRequestError(fiber.StatusTooManyRequests, err.Error())
+	reqErr.retryAfter = registryFullRetryAfter
+	return reqErr
+}
+
+// respond is the Fiber side of every handle* function: it sends response
+// as JSON, or the status and body of a requestError
+func respond(c *fiber.Ctx, response any, err error) error {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		if reqErr.retryAfter > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(reqErr.retryAfter.Seconds()))))
+		}
+		return send(c, reqErr.status, reqErr.body)
+	}
+	if err != nil {
+		return send(c, fiber.StatusInternalServerError, fiber.Map{"error": err.Error()})
+	}
+	return send(c, fiber.StatusOK, response)
+}
+
+func processOffer(c *fiber.Ctx) error {
+	var request OfferRequest
+	if err := parseRequest(c, &request); err != nil {
+		return send(c, fiber.StatusBadRequest, fiber.Map{"error": "Invalid request"})
+	}
+	response, err := handleOffer(request)
+	setCapacityHeaders(c)
+	// Only this request's handler waits; the call is already set up.
+	// Shutdown cuts the wait short rather than holding the worker. Errors
+	// go out at once so they don't skew the simulated latency.
+	if err == nil {
+		sleepContext(callsCtx, currentConfig().SignalingLatency.response)
+	}
+	return respond(c, response, err)
+}
+
+func processAnswer(c *fiber.Ctx) error {
+	var request AnswerRequest
+	if err := parseRequest(c, &request); err != nil {
+		return send(c, fiber.StatusBadRequest, fiber.Map{"error": "Invalid request"})
+	}
+	request.IncludeCandidates = c.QueryBool("include_candidates")
+	response, err := handleAnswer(request)
+	setCapacityHeaders(c)
+	return respond(c, response, err)
+}
+
+func processAction(c *fiber.Ctx) error {
+	// A SUT may echo our own webhook envelope back instead of a flat action
+	var event Event
+	if err := parseRequest(c, &event); err == nil && len(event.Entry) > 0 {
+		response, err := handleEventActions(event)
+		return respond(c, response, err)
+	}
+
+	var action ActionRequest
+	if err := parseRequest(c, &action); err != nil {
+		return send(c, fiber.StatusBadRequest, fiber.Map{"error": "Invalid request body"})
+	}
+	response, err := handleAction(action)
+	return respond(c, response, err)
+}
+
+// eventActions turns each call in a webhook envelope into an action. A
+// connect on one of our calls carries the answer, so it is an accept.
+func eventActions(event Event) []ActionRequest {
+	var actions []ActionRequest
+	for _, entry := range event.Entry {
+		for _, change := range entry.Changes {
+			for _, call := range change.Value.Calls {
+				action := call.Event
+				if action == "connect" {
+					action = "accept"
+				}
+				actions = append(actions, ActionRequest{
+					CallID:           call.ID,
+					Action:           action,
+					Connection:       call.Connection,
+					Session:          call.Session,
+					MessagingProduct: change.Value.MessagingProduct,
+				})
+			}
+		}
+	}
+	return actions
+}
+
+// handleEventActions applies every call in a webhook envelope. A single
+// call gets the same response as a flat action; several get a list.
+func handleEventActions(event Event) (any, error) {
+	actions := eventActions(event)
+	if len(actions) == 0 {
+		return nil, newRequestError(fiber.StatusBadRequest, "entry[].changes[].value.calls[] is empty")
+	}
+	if len(actions) == 1 {
+		return handleAction(actions[0])
+	}
+
+	responses := make([]any, len(actions))
+	for i, action := range actions {
+		response, err := handleAction(action)
+		var reqErr *requestError
+		switch {
+		case errors.As(err, &reqErr):
+			response = reqErr.body
+		case err != nil:
+			response = fiber.Map{"error": err.Error(), "call_id": action.CallID}
+		}
+		responses[i] = response
+	}
+	return responses, nil
+}
+
+// handleOffer creates an outbound call. The response is the webhook event,
+// or a MinimalOfferResponse in minimal response mode.
+func handleOffer(request OfferRequest) (any, error) {
+	cfg := currentConfig()
+	if err := fillMissingNumbers(&request, cfg.MissingNumberPolicy); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if cfg.RejectSelfCalls && request.From != "" && request.From == request.To {
+		return nil, newRequestError(fiber.StatusBadRequest, "from and to must be different numbers")
+	}
+
+	product, err := resolveMessagingProduct(request.MessagingProduct)
+	if err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	request.MessagingProduct = product
+
+	// Spread callbacks over --callback-urls unless the request names its own
+	if request.CallbackURL == "" {
+		request.CallbackURL = config.CallbackURLs.pick()
+	}
+
+	// pion has no DTMF sender (RFC 4733 events must share the audio
+	// track's SSRC), so fail loudly rather than run an IVR test without tones
+	if request.DTMFSequence != "" {
+		return nil, newRequestError(fiber.StatusBadRequest, "dtmf_sequence is not supported: DTMF sending is not implemented")
+	}
+
+	if request.Media != "" {
+		if err := validateMediaKind(request.Media); err != nil {
+			return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	if err := validateTimeoutSeconds(request.TimeoutSeconds); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := resolveTTLSeconds(request.TTLSeconds, &request.TimeoutSeconds); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if request.AudioFile != "" {
+		// Whether the file can play depends on the call's codec
+		if request.Media == "" {
+			request.Media = cfg.MediaMix.pick()
+		}
+		source, err := requestAudioSource(cfg.AudioDir, request.AudioFile, request.Media)
+		if err != nil {
+			return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+		}
+		request.audioSource = source
+	}
+
+	responseMode := request.ResponseMode
+	if responseMode == "" {
+		responseMode = cfg.ResponseMode
+	}
+	if err := validateResponseMode(responseMode); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+
+	priority, err := callPriority(request.Metadata)
+	if err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if draining.Load() {
+		return nil, newRequestError(fiber.StatusServiceUnavailable, errShuttingDown)
+	}
+	release, err := admitCall(priority)
+	if err != nil {
+		return nil, registryFull(err)
+	}
+	defer release()
+
+	if ok, retryAfter := pcBreaker.Allow(); !ok {
+		return nil, shedLoad(retryAfter)
+	}
+
+	scenario := metrics.scenario(request.Metadata[MetadataScenario])
+	started := time.Now()
+	response, offer, err := generateSDPOffer(request)
+	// Registered now, or failed; either way the slot is no longer pending
+	release()
+	if err != nil {
+		events.Publish(LifecycleEvent{
+			Type:      EventFailed,
+			CallID:    request.CallID,
+			Direction: DirectionUserInitiated,
+			Scenario:  request.Metadata[MetadataScenario],
+		})
+		return nil, fmt.Errorf("Error generating offer: %v", err)
+	}
+
+	scenario.OfferLatency.Observe(time.Since(started))
+
+	var status string
+	if request.WaitForConnect {
+		status = waitForConnect(offer.CallID, cfg.WaitForConnectTimeout)
+		// The event response reports it as the call's status
+		response = withCallStatus(response, status)
+	}
+
+	// Minimal clients only need the SDP; webhook-style clients get the full event
+	if responseMode == ResponseModeMinimal {
+		return MinimalOfferResponse{
+			CallID: offer.CallID,
+			SDP:    offer.Offer.SDP,
+			Type:   offer.Offer.Type,
+			Status: status,
+		}, nil
+	}
+
+	return response, nil
+}
+
+// withCallStatus returns event with its call's status set. The entry,
+// change and call slices are copied first: the callback sink was handed
+// the same event and may still be marshalling it.
+func withCallStatus(event Event, status string) Event {
+	entry := event.Entry[0]
+	change := entry.Changes[0]
+	call := change.Value.Calls[0]
+	call.Status = status
+	change.Value.Calls = append([]Call{call}, change.Value.Calls[1:]...)
+	entry.Changes = append([]Change{change}, entry.Changes[1:]...)
+	event.Entry = append([]Entry{entry}, event.Entry[1:]...)
+	return event
+}
+
+// handleAnswer answers an inbound offer as a new call
+func handleAnswer(request AnswerRequest) (any, error) {
+	if request.Action != "connect" && request.Action != "reject" {
+		return nil, newRequestError(fiber.StatusBadRequest, "Invalid action")
+	}
+
+	product, err := resolveMessagingProduct(request.MessagingProduct)
+	if err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	request.MessagingProduct = product
+
+	// Spread callbacks over --callback-urls unless the request names its own
+	if request.CallbackURL == "" {
+		request.CallbackURL = config.CallbackURLs.pick()
+	}
+
+	if err := validateTimeoutSeconds(request.TimeoutSeconds); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := resolveTTLSeconds(request.TTLSeconds, &request.TimeoutSeconds); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := validateGatherDelayMs(request.GatherDelayMs); err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	if request.AudioFile != "" {
+		source, err := requestAudioSource(config.AudioDir, request.AudioFile, MediaOpus)
+		if err != nil {
+			return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+		}
+		request.audioSource = source
+	}
+
+	if config.GlareRole != "" && request.CallID != "" {
+		if val, ok := ActionChannels.Load(request.CallID); ok {
+			if err := resolveGlare(request.CallID, val.(*CallIDDetails)); err != nil {
+				return nil, callError(fiber.StatusConflict, err.Error(), request.CallID)
+			}
+		}
+	}
+
+	priority, err := callPriority(request.Metadata)
+	if err != nil {
+		return nil, newRequestError(fiber.StatusBadRequest, err.Error())
+	}
+	// A busy callee declines before any media is set up
+	if request.Action == "reject" || rand.Float64() < config.AnswerRejectRate {
+		return rejectInboundCall(request), nil
+	}
+
+	if draining.Load() {
+		return nil, newRequestError(fiber.StatusServiceUnavailable, errShuttingDown)
+	}
+	release, err := admitCall(priority)
+	if err != nil {
+		return nil, registryFull(err)
+	}
+	defer release()
+
+	if ok, retryAfter := pcBreaker.Allow(); !ok {
+		return nil, shedLoad(retryAfter)
+	}
+
+	response, err := generateSDPAnswer(request)
+	release()
+	if err != nil {
+		events.Publish(LifecycleEvent{
+			Type:      EventFailed,
+			CallID:    request.CallID,
+			Direction: DirectionBusinessInitiated,
+			Scenario:  request.Metadata[MetadataScenario],
+		})
+		if errors.Is(err, errAnswerTimeout) {
+			return nil, newRequestError(fiber.StatusGatewayTimeout, err.Error())
+		}
+		return nil, fmt.Errorf("Error generating answer: %v", err)
+	}
+
+	if request.IncludeCandidates {
+		// The call is up by now, so a parse failure only loses the listing
+		if response.Candidates, err = sdpCandidates(response.Answer.SDP); err != nil {
+			log.Printf("❌ %s Listing answer candidates: %v\n", response.CallID, err)
+		}
+	}
+	return response, nil
+}
+
+// rejectInboundCall declines an inbound offer without a PeerConnection or
+// registering the call; it only publishes the terminate, so the CDR,
+// callback and metrics see a rejected call
+func rejectInboundCall(request AnswerRequest) fiber.Map {
+	callID := request.CallID
+	if callID == "" {
+		callID = uuid.New().String()
+	}
+	to := request.To
+	if to == "" {
+		to = toPool.Next()
+	}
+
+	scenario := metrics.resolveScenario(request.Metadata[MetadataScenario])
+	details := newCallIDDetails(nil, DirectionBusinessInitiated, CallStateClosed, scenario)
+	details.cancel()
+	details.to = to
+	details.messagingProduct = request.MessagingProduct
+	details.callbackData = request.CallbackData
+	details.callbackURL = request.CallbackURL
+
+	record := newCallDetailRecord(callID, details, CallStateClosed, ReasonReject)
+	events.Publish(LifecycleEvent{
+		Type:      EventTerminated,
+		CallID:    callID,
+		Direction: details.direction,
+		Scenario:  details.scenario,
+		Details:   details,
+		Record:    &record,
+	})
+	log.Printf("%s Inbound call rejected\n", callID)
+
+	response := fiber.Map{"call_id": callID, "status": "rejected"}
+	if request.CallbackData != "" {
+		response["biz_opaque_callback_data"] = request.CallbackData
+	}
+	return response
+}
+
+// callGoneResponse answers an action for a call_id that is not registered
+func callGoneResponse(action ActionRequest) fiber.Map {
+	return fiber.Map{
+		"status":  "No corresponding offer for this call_id or already closed",
+		"call_id": action.CallID,
+		"action":  action.Action,
+	}
+}
+
+// expiredAccept answers an accept for a call whose teardown has begun with
+// --expired-accept-status, or the legacy already-closed body when it is 0
+func expiredAccept(action ActionRequest) (any, error) {
+	metrics.ExpiredAccepts.Add(1)
+	log.Printf("⚠️ %s %s arrived after teardown began\n", action.CallID, action.Action)
+	if config.ExpiredAcceptStatus == 0 {
+		return callGoneResponse(action), nil
+	}
+	return nil, callError(config.ExpiredAcceptStatus, "Call expired: teardown has begun", action.CallID)
+}
+
+// handleAction applies an action to a registered call
+func handleAction(action ActionRequest) (any, error) {
+	log.Printf("📩 Parsed action request: %s %s\n", action.CallID, action.Action)
+
+	// mutex.Lock()
+	// pc, exists := callIDToOffer[action.CallID]
+	// mutex.Unlock()
+	val, ok := ActionChannels.Load(action.CallID)
+
+	// Read-only: report the call's current state without touching it
+	if action.Action == "status" {
+		if !ok {
+			return nil, &requestError{status: fiber.StatusNotFound, body: callGoneResponse(action)}
+		}
+		return newCallStatsResponse(action.CallID, val.(*CallIDDetails)), nil
+	}
+
+	if !ok {
+		// Return a proper JSON response with status, CallID, and Action details
+		return callGoneResponse(action), nil
+	}
+
+	details := val.(*CallIDDetails)
+	pc := details.pc
+	if pc == nil {
+		return callGoneResponse(action), nil
+	}
+
+	validCloseActions := map[string]bool{
+		"terminate": true,
+		"reject":    true,
+		"hangup":    true,
+	}
+
+	if _, exists := validCloseActions[action.Action]; exists {
+		// mutex.Lock()
+		// delete(callIDToOffer, action.CallID)
+		// mutex.Unlock()
+		teardownCall(action.CallID, action.Action)
+	}
+
+	// Trickle ICE: a remote candidate for an offer that has been or is about to be accepted
+	if action.Action == "candidate" {
+		if action.Candidate == nil {
+			return nil, callError(fiber.StatusBadRequest, "candidate is missing", action.CallID)
+		}
+		queued, err := details.addRemoteCandidate(*action.Candidate)
+		if err != nil {
+			return nil, callError(fiber.StatusBadRequest, fmt.Sprintf("Invalid candidate: %v", err), action.CallID)
+		}
+		return actionProcessed(action.CallID, details, fiber.Map{"queued": queued}), nil
+	}
+
+	// A heartbeat keeps the call alive for another full auto-removal timeout
+	if action.Action == "heartbeat" {
+		expiresAt, err := details.extendAutoRemove()
+		if err != nil {
+			return nil, callError(fiber.StatusConflict, err.Error(), action.CallID)
+		}
+		debugf("%s Heartbeat, call now expires at %s", action.CallID, expiresAt.Format(time.RFC3339))
+		return actionProcessed(action.CallID, details, fiber.Map{"expires_at": expiresAt.UTC()}), nil
+	}
+
+	if action.Action == "mute" || action.Action == "unmute" {
+		muted := action.Action == "mute"
+		if details.muted.Swap(muted) != muted {
+			if muted {
+				details.addTimeline(TimelineMuted, "")
+			} else {
+				details.addTimeline(TimelineUnmuted, "")
+			}
+		}
+		return actionProcessed(action.CallID, details, fiber.Map{"muted": muted}), nil
+	}
+
+	// pre_accept carries the answer early so ringback can play; without
+	// --early-media-file it is acknowledged and ignored
+	isPreAccept := action.Action == "pre_accept" && currentConfig().EarlyMediaFile != ""
+	if action.Action == "accept" || isPreAccept {
+		// The call may have timed out between the registry lookup and now;
+		// its PeerConnection is closing, so don't negotiate on it
+		if details.State() == CallStateClosed {
+			return expiredAccept(action)
+		}
+
+		sdpString, err := extractAcceptSDP(action)
+		if err != nil {
+			return nil, callError(fiber.StatusBadRequest, err.Error(), action.CallID)
+		}
+		debugSDP(action.CallID, "remote answer ("+action.Action+")", sdpString)
+
+		if config.ValidateAnswer {
+			if err := validateAnswer(pc.LocalDescription().SDP, sdpString); err != nil {
+				return nil, callError(fiber.StatusBadRequest, fmt.Sprintf("Incompatible answer: %v", err), action.CallID)
+			}
+		}
+
+		if err := acquireAcceptSlot(action.CallID, details); err != nil {
+			return nil, err
+		}
+
+		// A half-open call that finally gets its accept is no longer half-open
+		if !isPreAccept && !details.transition(CallStateOffered, CallStateAccepted) {
+			details.transition(CallStateHalfOpen, CallStateAccepted)
+		}
+
+		// if ch, ok := ActionChannels.Load(action.CallID); ok {
+		log.Printf("📩 Sending action to channel: %s %s\n", action.CallID, action.Action)
+		// ch := details.ch
+		// Never block the handler if the call's loop has already exited
+		select {
+		case details.ch <- ActionData{
+			Action: action.Action,
+			Data: SessionDescription{
+				Type: "answer",
+				SDP:  sdpString,
+			},
+		}:
+		case <-details.ctx.Done():
+			return expiredAccept(action)
+		}
+
+	}
+
+	return actionProcessed(action.CallID, details, nil), nil
+}
+
+// actionProcessed is the response to an action on a live call, with any
+// action-specific fields. It echoes the call's biz_opaque_callback_data,
+// as the real API does, so clients can correlate it.
+func actionProcessed(callID string, details *CallIDDetails, fields fiber.Map) fiber.Map {
+	response := fiber.Map{
+		"status":  "Action processed successfully",
+		"call_id": callID,
+	}
+	for key, value := range fields {
+		response[key] = value
+	}
+	if details.callbackData != "" {
+		response["biz_opaque_callback_data"] = details.callbackData
+	}
+	return response
+}
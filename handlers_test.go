@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestOfferResponseLatencyEndsOnShutdown(t *testing.T) {
+	app := newTestApp(t, func(cfg *Config) {
+		if err := cfg.SignalingLatency.Set("10s,0s"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	callsCtx = ctx
+	t.Cleanup(func() { callsCtx = context.Background() })
+
+	time.AfterFunc(200*time.Millisecond, cancel)
+	started := time.Now()
+	resp, _ := offer(t, app, nil)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("offer response took %s after shutdown, want it cut short", elapsed)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("offer = %d, want 200", resp.StatusCode)
+	}
+}
+
+// The simulated latency is for calls that were set up; a rejected offer
+// is answered at once
+func TestOfferErrorsSkipResponseLatency(t *testing.T) {
+	app := newTestApp(t, func(cfg *Config) {
+		if err := cfg.SignalingLatency.Set("10s,0s"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	started := time.Now()
+	resp, _ := doJSON(t, app, fiber.MethodPost, "/load/offer", map[string]any{"to": "15550001", "dtmf_sequence": "1"})
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("offer = %d, want 400", resp.StatusCode)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("rejected offer took %s, want no simulated latency", elapsed)
+	}
+}
+
+func TestWithCallStatusCopiesEvent(t *testing.T) {
+	published := Event{Entry: []Entry{{Changes: []Change{{Value: Value{Calls: []Call{{ID: "call-1"}}}}}}}}
+
+	response := withCallStatus(published, "connected")
+	if got := response.Entry[0].Changes[0].Value.Calls[0].Status; got != "connected" {
+		t.Errorf("response status = %q, want connected", got)
+	}
+	if got := published.Entry[0].Changes[0].Value.Calls[0].Status; got != "" {
+		t.Errorf("published event status = %q, want it untouched", got)
+	}
+}
+
+// statusOf is the HTTP status respond would send for a handler's error
+func statusOf(err error) int {
+	var reqErr *requestError
+	switch {
+	case err == nil:
+		return fiber.StatusOK
+	case errors.As(err, &reqErr):
+		return reqErr.status
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// remoteOffer is an audio offer from a real PeerConnection, as an inbound
+// call to answer
+func remoteOffer(t testing.TB) string {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return pc.LocalDescription().SDP
+}
+
+func TestHandleOffer(t *testing.T) {
+	newTestApp(t, func(cfg *Config) { cfg.StrictProduct = true })
+
+	tests := []struct {
+		name    string
+		request OfferRequest
+		want    int
+	}{
+		{"minimal", OfferRequest{To: "15550001", From: "15550002", ResponseMode: ResponseModeMinimal}, fiber.StatusOK},
+		{"event", OfferRequest{To: "15550001", From: "15550002"}, fiber.StatusOK},
+		{"unknown response mode", OfferRequest{To: "15550001", ResponseMode: "xml"}, fiber.StatusBadRequest},
+		{"product not allowed", OfferRequest{To: "15550001", MessagingProduct: "sms"}, fiber.StatusBadRequest},
+		{"dtmf", OfferRequest{To: "15550001", DTMFSequence: "123#"}, fiber.StatusBadRequest},
+		{"negative timeout", OfferRequest{To: "15550001", TimeoutSeconds: -2}, fiber.StatusBadRequest},
+		{"ttl over an hour", OfferRequest{To: "15550001", TTLSeconds: 7200}, fiber.StatusBadRequest},
+		{"audio file outside audio dir", OfferRequest{To: "15550001", AudioFile: "../output20ms.ogg"}, fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handleOffer(tt.request)
+			if got := statusOf(err); got != tt.want {
+				t.Fatalf("status = %d (%v), want %d", got, err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			var callID string
+			switch r := response.(type) {
+			case MinimalOfferResponse:
+				callID = r.CallID
+			case Event:
+				callID = payloadCallID(r)
+			default:
+				t.Fatalf("response is %T", response)
+			}
+			if _, ok := ActionChannels.Load(callID); !ok {
+				t.Errorf("call %q not registered", callID)
+			}
+		})
+	}
+}
+
+func TestHandleAnswer(t *testing.T) {
+	newTestApp(t, func(cfg *Config) { cfg.StrictProduct = true })
+	offer := remoteOffer(t)
+	negative := -1
+
+	tests := []struct {
+		name    string
+		request AnswerRequest
+		want    int
+		status  string
+	}{
+		{"connect", AnswerRequest{CallID: "inbound-1", Action: "connect", Session: SessionDescription{Type: "offer", SDP: offer}}, fiber.StatusOK, ""},
+		{"reject", AnswerRequest{CallID: "inbound-2", Action: "reject"}, fiber.StatusOK, "rejected"},
+		{"unknown action", AnswerRequest{CallID: "inbound-3", Action: "ring"}, fiber.StatusBadRequest, ""},
+		{"product not allowed", AnswerRequest{CallID: "inbound-4", Action: "connect", MessagingProduct: "sms"}, fiber.StatusBadRequest, ""},
+		{"negative gather delay", AnswerRequest{CallID: "inbound-5", Action: "connect", GatherDelayMs: &negative}, fiber.StatusBadRequest, ""},
+		{"ttl over an hour", AnswerRequest{CallID: "inbound-6", Action: "connect", TTLSeconds: 7200}, fiber.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handleAnswer(tt.request)
+			if got := statusOf(err); got != tt.want {
+				t.Fatalf("status = %d (%v), want %d", got, err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if tt.status != "" {
+				if got := response.(fiber.Map)["status"]; got != tt.status {
+					t.Errorf("status field = %v, want %s", got, tt.status)
+				}
+				return
+			}
+			if _, ok := ActionChannels.Load(tt.request.CallID); !ok {
+				t.Errorf("call %s not registered", tt.request.CallID)
+			}
+		})
+	}
+}
+
+func TestHandleAction(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	// "live" stands for the call_id of a fresh offer
+	tests := []struct {
+		name   string
+		action ActionRequest
+		want   int
+		gone   bool
+	}{
+		{"status of unknown call", ActionRequest{CallID: "missing", Action: "status"}, fiber.StatusNotFound, false},
+		{"terminate unknown call", ActionRequest{CallID: "missing", Action: "terminate"}, fiber.StatusOK, true},
+		{"accept unknown call", ActionRequest{CallID: "missing", Action: "accept"}, fiber.StatusOK, true},
+		{"status", ActionRequest{CallID: "live", Action: "status"}, fiber.StatusOK, false},
+		{"accept without sdp", ActionRequest{CallID: "live", Action: "accept"}, fiber.StatusBadRequest, false},
+		{"accept with offer type", ActionRequest{CallID: "live", Action: "accept", Session: map[string]any{"sdp_type": "offer", "sdp": "v=0"}}, fiber.StatusBadRequest, false},
+		{"candidate missing", ActionRequest{CallID: "live", Action: "candidate"}, fiber.StatusBadRequest, false},
+		{"mute", ActionRequest{CallID: "live", Action: "mute"}, fiber.StatusOK, false},
+		{"heartbeat", ActionRequest{CallID: "live", Action: "heartbeat"}, fiber.StatusOK, false},
+		{"terminate", ActionRequest{CallID: "live", Action: "terminate"}, fiber.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := tt.action
+			if action.CallID == "live" {
+				_, action.CallID = offer(t, app, nil)
+			}
+			response, err := handleAction(action)
+			if got := statusOf(err); got != tt.want {
+				t.Fatalf("status = %d (%v), want %d", got, err, tt.want)
+			}
+			if tt.gone {
+				if _, ok := response.(fiber.Map)["status"]; !ok {
+					t.Errorf("response = %v, want the call-gone reply", response)
+				}
+			}
+		})
+	}
+
+	t.Run("accept", func(t *testing.T) {
+		callID, sdp := offerSDP(t, app)
+		if _, err := handleAction(acceptAction(callID, answerSDP(t, sdp))); err != nil {
+			t.Fatalf("accept: %v", err)
+		}
+		val, _ := ActionChannels.Load(callID)
+		if state := val.(*CallIDDetails).State(); state != CallStateAccepted {
+			t.Errorf("state after accept = %s, want %s", state, CallStateAccepted)
+		}
+	})
+}
+
+func TestMalformedBodies(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	for _, path := range []string{"/load/offer", "/load/calls", "/load/action"} {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(`{"to": `))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("POST %s with a truncated body = %d, want 400", path, resp.StatusCode)
+		}
+	}
+}
+
+// Under --api-compat=legacy an offer's renamed fields arrive under their
+// legacy wire names, as they do on answers and actions
+func TestLegacyOffer(t *testing.T) {
+	app := newTestApp(t, func(cfg *Config) {
+		cfg.APICompat = "legacy"
+		cfg.StrictProduct = true
+	})
+
+	resp, reply := doJSON(t, app, fiber.MethodPost, "/load/offer", map[string]any{
+		"to": "15550001", "product": "whatsapp", "response_mode": ResponseModeMinimal,
+	})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("offer = %d: %v", resp.StatusCode, reply)
+	}
+	val, ok := ActionChannels.Load(reply["call_id"])
+	if !ok {
+		t.Fatalf("call %v not registered", reply["call_id"])
+	}
+	if got := val.(*CallIDDetails).messagingProduct; got != "whatsapp" {
+		t.Errorf("messaging product = %q, want whatsapp", got)
+	}
+
+	resp, _ = doJSON(t, app, fiber.MethodPost, "/load/offer", map[string]any{"to": "15550001", "product": "sms"})
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("offer with a disallowed legacy product = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ready is set by main once the ICE and codec setup has succeeded and the
+// default audio has been loaded, just before the server starts listening
+var ready atomic.Bool
+
+// getHealthz answers 200 whenever the app is serving
+func getHealthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// getReadyz answers 200 while new calls are welcome: after startup and
+// until a shutdown signal, so a load balancer stops sending traffic while
+// in-flight calls drain
+func getReadyz(c *fiber.Ctx) error {
+	switch {
+	case !ready.Load():
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "starting"})
+	case draining.Load() || callsCtx.Err() != nil:
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "shutting_down"})
+	}
+	return c.JSON(fiber.Map{"status": "ready"})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+// iceConfiguration is built once at startup from --ice-config, --stun and
+// --turn; createPeerConnection clones it for every PeerConnection
+var iceConfiguration webrtc.Configuration
+
+// ICEConfigFile is the JSON read from --ice-config, e.g.
+//
+//	{"ice_servers": [
+//	  {"urls": ["stun:stun.example.com:3478"]},
+//	  {"urls": ["turn:turn.example.com:3478?transport=udp"], "username": "load", "credential": "secret"}
+//	]}
+type ICEConfigFile struct {
+	ICEServers []webrtc.ICEServer `json:"ice_servers"`
+}
+
+// turnServers collects repeated --turn flags, each "url,username,credential"
+type turnServers []webrtc.ICEServer
+
+func (t *turnServers) String() string {
+	urls := make([]string, 0, len(*t))
+	for _, server := range *t {
+		urls = append(urls, server.URLs...)
+	}
+	return strings.Join(urls, ",")
+}
+
+func (t *turnServers) Set(value string) error {
+	parts := strings.SplitN(value, ",", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("want url,username,credential")
+	}
+	*t = append(*t, webrtc.ICEServer{
+		URLs:       []string{strings.TrimSpace(parts[0])},
+		Username:   strings.TrimSpace(parts[1]),
+		Credential: parts[2],
+	})
+	return nil
+}
+
+// loadICEServers builds iceConfiguration from the config file, if any,
+// followed by the --stun and --turn servers. Any server that pion would
+// reject or that could never gather a candidate is an error, so a typo
+// fails at boot instead of silently leaving calls host-only.
+func loadICEServers(cfg Config) error {
+	var servers []webrtc.ICEServer
+	if cfg.ICEConfigFile != "" {
+		data, err := os.ReadFile(cfg.ICEConfigFile)
+		if err != nil {
+			return err
+		}
+		var file ICEConfigFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("parsing %s: %w", cfg.ICEConfigFile, err)
+		}
+		if len(file.ICEServers) == 0 {
+			return fmt.Errorf("%s has no ice_servers", cfg.ICEConfigFile)
+		}
+		servers = append(servers, file.ICEServers...)
+	}
+	for _, url := range cfg.STUNServers {
+		servers = append(servers, webrtc.ICEServer{URLs: []string{url}})
+	}
+	servers = append(servers, cfg.TURNServers...)
+
+	if len(servers) > 0 && cfg.HostOnly {
+		return fmt.Errorf("ICE servers can't be used with --host-only")
+	}
+	for i, server := range servers {
+		if err := validateICEServer(server); err != nil {
+			return fmt.Errorf("ice server %d: %w", i+1, err)
+		}
+	}
+
+	iceConfiguration = webrtc.Configuration{ICEServers: servers}
+	for _, server := range servers {
+		log.Printf("✅ Using ICE server %s\n", strings.Join(server.URLs, ", "))
+	}
+	return nil
+}
+
+func validateICEServer(server webrtc.ICEServer) error {
+	if len(server.URLs) == 0 {
+		return fmt.Errorf("no urls")
+	}
+	for _, raw := range server.URLs {
+		url, err := ice.ParseURL(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", raw, err)
+		}
+		if (url.Scheme == ice.SchemeTypeTURN || url.Scheme == ice.SchemeTypeTURNS) && (server.Username == "" || server.Credential == "") {
+			return fmt.Errorf("%s: TURN needs a username and credential", raw)
+		}
+	}
+	return nil
+}
+
+// peerConfiguration is a copy of iceConfiguration for one PeerConnection
+func peerConfiguration() webrtc.Configuration {
+	pcConfig := iceConfiguration
+	pcConfig.ICEServers = slices.Clone(iceConfiguration.ICEServers)
+	return pcConfig
+}
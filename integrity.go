@@ -0,0 +1,39 @@
+package main
+
+import (
+	"hash/crc32"
+	"log"
+)
+
+// MediaIntegrity is the --media-checksum result for one call
+type MediaIntegrity struct {
+	SamplesChecked int64 `json:"samples_checked"`
+	BytesChecked   int64 `json:"bytes_checked"`
+	Mismatches     int64 `json:"mismatches"`
+}
+
+// checkSample compares the bytes about to be written for sample i of audio
+// with the CRC-32 taken as the page was read from the file, so any change
+// made between the file and WriteSample shows up as a mismatch
+func (d *CallIDDetails) checkSample(callID string, audio *PrecompiledMedia, i int, data []byte) {
+	d.integrity.checked.Add(1)
+	d.integrity.bytes.Add(int64(len(data)))
+	want := audio.Checksums[i]
+	if got := crc32.ChecksumIEEE(data); got != want {
+		if d.integrity.mismatches.Add(1) == 1 {
+			log.Printf("❌ %s Sample %d of %s differs from the file: crc32 %08x, want %08x\n", callID, i, audio.Filename, got, want)
+		}
+		metrics.MediaMismatches.Add(1)
+	}
+}
+
+func (d *CallIDDetails) Integrity() *MediaIntegrity {
+	if !config.MediaChecksum {
+		return nil
+	}
+	return &MediaIntegrity{
+		SamplesChecked: d.integrity.checked.Load(),
+		BytesChecked:   d.integrity.bytes.Load(),
+		Mismatches:     d.integrity.mismatches.Load(),
+	}
+}
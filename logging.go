@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Log levels for --log-level. Existing log lines are info; debug adds
+// output too verbose for normal runs, such as full SDP.
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+func validateLogLevel(level string) error {
+	switch level {
+	case LogLevelInfo, LogLevelDebug:
+		return nil
+	}
+	return fmt.Errorf("invalid log level %q (want %s or %s)", level, LogLevelInfo, LogLevelDebug)
+}
+
+func debugf(format string, args ...any) {
+	if currentConfig().LogLevel == LogLevelDebug {
+		log.Printf("🐛 "+format, args...)
+	}
+}
+
+// debugSDP logs a complete SDP at debug level
+func debugSDP(callID, label, sdp string) {
+	debugf("%s %s SDP:\n%s", callID, label, sdp)
+}
@@ -1,42 +1,44 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/google/uuid"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
-	"github.com/pion/webrtc/v4/pkg/media/oggreader"
 )
 
 // var callIDToOffer = make(map[string]*webrtc.PeerConnection)
 // var mutex = &sync.Mutex{}
 
-func createPeerConnection() (*webrtc.PeerConnection, error) {
-	// config := webrtc.Configuration{
-	// 	ICEServers: []webrtc.ICEServer{
-	// 		{
-	// 			URLs: []string{"stun:stun1.l.google.com:19302"},
-	// 		},
-	// 	},
-	// }
-	config := webrtc.Configuration{}
-	return webrtc.NewPeerConnection(config)
+func createPeerConnection(api *webrtc.API) (*webrtc.PeerConnection, error) {
+	// ICE servers come from --ice-config, --stun and --turn; with none
+	// (always the case with --host-only) only host candidates are gathered
+	pcConfig := peerConfiguration()
+	pcConfig.Certificates = pooledCertificates()
+	pc, err := api.NewPeerConnection(pcConfig)
+	if err != nil {
+		pcBreaker.Failure()
+	}
+	return pc, err
 }
 
-func generateSDPOffer(request OfferRequest) (Event, error) {
+func generateSDPOffer(request OfferRequest) (Event, OfferResponse, error) {
+	cfg := currentConfig()
 
 	// Store peer connection
 	callID := request.CallID
@@ -46,39 +48,87 @@ func generateSDPOffer(request OfferRequest) (Event, error) {
 	}
 	// log.Println("Generated Call ID:", callID)
 
-	pc, err := createPeerConnection()
+	// ✅ Load media up front so a missing or oversized file fails this request
+	source := request.audioSource
+	if source == "" {
+		source = defaultAudioSource(cfg)
+	}
+	audio, err := loadPrecompiledMedia(source)
+	if err != nil {
+		return Event{}, OfferResponse{}, err
+	}
+
+	kind := request.Media
+	if kind == "" {
+		kind = cfg.MediaMix.pick()
+	}
+	if kind == MediaPCMU {
+		audio = pcmuMedia(audio)
+	} else if audio, err = opusMedia(audio); err != nil {
+		return Event{}, OfferResponse{}, err
+	}
+
+	api := webrtcAPI
+	if request.Simulcast {
+		api = simulcastAPI
+	}
+	pc, err := createPeerConnection(api)
 	if err != nil {
-		return Event{}, err
+		return Event{}, OfferResponse{}, err
 	}
 
 	// pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 	// 	log.Printf("%s ICE Connection State has changed: %s\n", callID, connectionState.String())
 	// })
 
-	// ✅ Create an Opus track
-	audioTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion",
-	)
+	// ✅ Create the audio track
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(audioCodec(kind), "audio", "pion", simulcastTrackOptions(request.Simulcast)...)
 	if err != nil {
 		log.Println("❌ Error creating audio track:", err)
 		pc.Close()
-		return Event{}, err
+		return Event{}, OfferResponse{}, err
 	}
 
 	// ✅ Add track to PeerConnection
 	rtpSender, err := pc.AddTrack(audioTrack)
 	if err != nil {
 		log.Println("❌ Error adding audio track:", err)
+		pcBreaker.Failure()
 		pc.Close()
-		return Event{}, err
+		return Event{}, OfferResponse{}, err
 	}
+	pcBreaker.Success()
 	log.Println("✅ Audio track added successfully")
 
+	var simulcast []simulcastLayer
+	if request.Simulcast {
+		if simulcast, err = addSimulcastLayers(audioCodec(kind), rtpSender); err != nil {
+			log.Println("❌ Error adding simulcast layers:", err)
+			pc.Close()
+			return Event{}, OfferResponse{}, err
+		}
+	}
+
+	// Video calls negotiate a VP8 track to exercise video m-lines; no frames are sent on it
+	if kind == MediaVideo {
+		videoTrack, err := webrtc.NewTrackLocalStaticSample(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "pion",
+		)
+		if err == nil {
+			_, err = pc.AddTrack(videoTrack)
+		}
+		if err != nil {
+			log.Println("❌ Error adding video track:", err)
+			pc.Close()
+			return Event{}, OfferResponse{}, err
+		}
+	}
+
 	// Create an offer
 	offer, err := pc.CreateOffer(nil)
 	if err != nil {
 		pc.Close()
-		return Event{}, err
+		return Event{}, OfferResponse{}, err
 	}
 
 	// Start ICE gathering and wait for completion
@@ -88,7 +138,7 @@ func generateSDPOffer(request OfferRequest) (Event, error) {
 	err = pc.SetLocalDescription(offer)
 	if err != nil {
 		pc.Close()
-		return Event{}, err
+		return Event{}, OfferResponse{}, err
 	}
 
 	// ✅ Wait for ICE gathering to complete
@@ -97,132 +147,433 @@ func generateSDPOffer(request OfferRequest) (Event, error) {
 	finalOffer := pc.LocalDescription()
 	if finalOffer == nil {
 		pc.Close()
-		return Event{}, fmt.Errorf("failed to retrieve local description")
+		return Event{}, OfferResponse{}, fmt.Errorf("failed to retrieve local description")
+	}
+
+	// Rewrite what we signal (see signaledSDP). pion rejects a
+	// modified SDP in SetLocalDescription, so only the signaled copy is changed.
+	offerSDP, err := signaledSDP(finalOffer.SDP)
+	if err != nil {
+		pc.Close()
+		return Event{}, OfferResponse{}, err
+	}
+	debugSDP(callID, "local offer", finalOffer.SDP)
+	if offerSDP != finalOffer.SDP {
+		debugSDP(callID, "signaled offer", offerSDP)
 	}
 
 	// mutex.Lock()
 	// callIDToOffer[callID] = pc
 	// mutex.Unlock()
-	ch := make(chan ActionData, 1)
-	closech := make(chan int, 1)
-
-	details := CallIDDetails{
-		pc: pc,
-		ch: ch, // buffered channel (optional)
+	closech := make(chan struct{})
+
+	scenario := metrics.resolveScenario(request.Metadata[MetadataScenario])
+	details := newCallIDDetails(pc, DirectionUserInitiated, CallStateOffered, scenario)
+	details.watchConnectionState(callID)
+	details.sender = rtpSender
+	details.from = request.From
+	details.priority, _ = callPriority(request.Metadata)
+	details.messagingProduct = request.MessagingProduct
+	details.to = request.To
+	details.callbackURL = request.CallbackURL
+	details.loopMedia = request.Loop
+	details.media = kind
+	details.simulcast = simulcast
+	if request.Simulcast {
+		registerSimulcastStreams(details, rtpSender)
 	}
+	details.recordICECredentials(callID)
 
 	ActionChannels.Store(callID, details)
+	watchHalfOpen(callID, details, cfg.HalfOpenTimeout)
 
 	// ✅ Auto remove PC after timeout
-	go autoRemovePeerConnection(callID, 45*time.Second, closech)
+	startAutoRemove(callID, details, request.TimeoutSeconds, closech)
 
 	offerResponse := OfferResponse{
+		CallID: callID,
 		Offer: Offer{
-			SDP:  finalOffer.SDP,
+			SDP:  offerSDP,
 			Type: finalOffer.Type.String(),
 		},
 	}
 
 	payload := createCallbackPayload(request, offerResponse.Offer, callID)
+	events.Publish(LifecycleEvent{
+		Type:      EventCreated,
+		CallID:    callID,
+		Direction: details.direction,
+		Scenario:  scenario,
+		Details:   details,
+		Payload:   &payload,
+	})
+
+	publishCallEvent(EventRinging, callID, details)
 
-	if request.CallbackURL != "" {
-		// Fire and forget (non-blocking)
-		sendCallbackAsync(request.CallbackURL, payload)
+	details.goTracked("offer_loop", func() { runOfferLoop(callID, details, closech, audio, audioTrack, rtpSender) })
+	scheduleAutoResolve(callID, details, offerSDP)
+
+	log.Println("Request Processed ", callID)
+
+	return payload, offerResponse, nil
+}
+
+// runOfferLoop drives an outbound call once its offer has been sent. It is
+// the only consumer of details.ch and implements this state machine:
+//
+//	offered --accept--> accepted: set remote description, start media
+//	offered --pre_accept--> ringing: set remote description, loop early media
+//	ringing --accept--> accepted: switch early media to the call's audio
+//	offered --answer-wait-max--> torn down (answer_wait_timeout)
+//	ringing --ICE connected, no accept within half-open-timeout--> torn down (half_open)
+//	offered/accepted --closech--> exit (autoRemovePeerConnection timed the call out)
+//	offered/accepted --ctx.Done--> exit (terminated, half-open or shutdown)
+//
+// A repeated accept once the call is accepted is logged and ignored.
+func runOfferLoop(callID string, details *CallIDDetails, closech <-chan struct{}, audio *PrecompiledMedia, audioTrack *webrtc.TrackLocalStaticSample, rtpSender *webrtc.RTPSender) {
+	cfg := currentConfig()
+	defer log.Println("Leaving generate loop: ", callID)
+	log.Printf("📩 Ready to receive generateSDPOffer answer: %s\n", callID)
+
+	// Set once a pre_accept starts early media
+	var switchMedia chan *PrecompiledMedia
+
+	// Bound how long we wait for an accept; a nil channel never fires
+	waiting := true
+	waitStarted := details.createdAt
+	var waitExpired <-chan time.Time
+	if cfg.AnswerWaitMax > 0 {
+		waitTimer := time.NewTimer(cfg.AnswerWaitMax)
+		defer waitTimer.Stop()
+		waitExpired = waitTimer.C
 	}
 
-	go func() {
-		defer log.Println("Leaving generate loop: ", callID)
-		log.Printf("📩 Ready to receive generateSDPOffer answer: %s\n", callID)
+	for {
 		select {
-		case action := <-ch:
+		case action := <-details.ch:
 			log.Printf("📩 Received action: %s %s\n", callID, action.Action)
-			// Process the answer received from `processAction`
-			if action.Action == "accept" {
-				var sdpString string
-				sdpString = action.Data.SDP
-
-				remoteDesc := webrtc.SessionDescription{
-					Type: webrtc.SDPTypeAnswer,
-					SDP:  sdpString,
+			if action.Action == "pre_accept" {
+				if waiting && switchMedia == nil {
+					switchMedia = startEarlyMedia(callID, details, action.Data.SDP, audioTrack, rtpSender)
 				}
-				if err := pc.SetRemoteDescription(remoteDesc); err != nil {
-					log.Printf("❌ Error setting remote description: %v", err)
+				continue
+			}
+			if action.Action != "accept" {
+				continue
+			}
+			if !waiting {
+				log.Printf("%s Ignoring accept, call already accepted\n", callID)
+				continue
+			}
+			waiting = false
+			waitExpired = nil
+			metrics.observeAnswerWait(AnswerWaitAccepted, time.Since(waitStarted))
+			details.addTimeline(TimelineAccepted, "")
+			publishCallEvent(EventAccepted, callID, details)
+
+			// Spread synchronized accept bursts; the HTTP response has already gone out
+			if cfg.AcceptJitter > 0 {
+				jitter := rand.N(cfg.AcceptJitter)
+				details.addTimeline(TimelineAcceptJitter, jitter.String())
+				select {
+				case <-time.After(jitter):
+				case <-details.ctx.Done():
+					log.Printf("%s Call closed during accept jitter, leaving generate loop\n", callID)
 					return
 				}
+			}
 
-				// Start streaming audio
-				go streamAudio(pc, "output20ms.ogg", audioTrack, rtpSender, callID)
+			// Media is already flowing from the pre_accept answer; swap in the call's audio
+			if switchMedia != nil {
+				switchMedia <- audio
+				log.Printf("%s ringing -> accepted, switching from early media\n", callID)
+				scheduleCallDuration(callID, details)
+				continue
 			}
-		}
-		select {
+
+			// Teardown may have begun while the accept was queued or jittered
+			if details.State() == CallStateClosed {
+				log.Printf("%s Call closed before its answer was applied, leaving generate loop\n", callID)
+				return
+			}
+
+			// Process the answer received from `processAction`
+			if err := details.setRemoteDescription(callID, action.Data.SDP); err != nil {
+				log.Printf("❌ Error setting remote description: %v", err)
+				continue
+			}
+			log.Printf("%s offered -> accepted, starting media\n", callID)
+
+			// Start streaming audio
+			if err := startMedia(callID, details, audio, audioTrack, rtpSender, nil); err != nil {
+				log.Printf("❌ %s Error starting media: %v\n", callID, err)
+				continue
+			}
+			scheduleCallDuration(callID, details)
+
+		case <-waitExpired:
+			metrics.observeAnswerWait(AnswerWaitTimeout, time.Since(waitStarted))
+			log.Printf("%s offered -> closed, no answer within %s\n", callID, cfg.AnswerWaitMax)
+			teardownCall(callID, ReasonAnswerWaitTimeout)
+			return
+
 		case <-closech:
+			if waiting {
+				metrics.observeAnswerWait(AnswerWaitTimeout, time.Since(waitStarted))
+			}
 			log.Printf("%s Timeout waiting for answer\n", callID)
 			return
+
+		case <-details.ctx.Done():
+			if waiting {
+				metrics.observeAnswerWait(AnswerWaitClosed, time.Since(waitStarted))
+			}
+			log.Printf("%s Call closed, leaving generate loop\n", callID)
+			return
 		}
-	}()
+	}
+}
 
-	log.Println("Request Processed ", callID)
+// defaultAutoRemoveTimeout is the --call-timeout default: how long a call
+// lives before the reaper removes it, unless the request or
+// --no-auto-remove says otherwise
+const defaultAutoRemoveTimeout = 45 * time.Second
+
+// maxCallTimeout bounds --call-timeout and ttl_seconds
+const maxCallTimeout = time.Hour
+
+// maxAnswerGatherDelay bounds --answer-gather-delay and gather_delay_ms, so
+// a slow-callee simulation cannot hold a request open indefinitely
+const maxAnswerGatherDelay = time.Minute
+
+func validateGatherDelayMs(delayMs *int) error {
+	if delayMs != nil && (*delayMs < 0 || time.Duration(*delayMs)*time.Millisecond > maxAnswerGatherDelay) {
+		return fmt.Errorf("gather_delay_ms must be between 0 and %d", maxAnswerGatherDelay.Milliseconds())
+	}
+	return nil
+}
+
+func validateTimeoutSeconds(timeoutSeconds int) error {
+	if timeoutSeconds < -1 {
+		return fmt.Errorf("timeout_seconds must be positive, or -1 to disable auto-removal")
+	}
+	return nil
+}
+
+// resolveTTLSeconds checks a request's ttl_seconds and folds it into
+// timeoutSeconds, which startAutoRemove reads; only one may be set
+func resolveTTLSeconds(ttlSeconds int, timeoutSeconds *int) error {
+	if ttlSeconds == 0 {
+		return nil
+	}
+	if ttlSeconds < 0 || time.Duration(ttlSeconds)*time.Second > maxCallTimeout {
+		return fmt.Errorf("ttl_seconds must be between 1 and %d", int(maxCallTimeout.Seconds()))
+	}
+	if *timeoutSeconds != 0 {
+		return fmt.Errorf("set ttl_seconds or timeout_seconds, not both")
+	}
+	*timeoutSeconds = ttlSeconds
+	return nil
+}
+
+// startAutoRemove launches the call's reaper unless auto-removal is off
+// for it; such calls live until terminated or shut down
+func startAutoRemove(callID string, details *CallIDDetails, timeoutSeconds int, closech chan struct{}) {
+	cfg := currentConfig()
+	if cfg.NoAutoRemove || timeoutSeconds == -1 {
+		log.Printf("%s Auto-removal disabled, call persists until terminated\n", callID)
+		return
+	}
+	timeout := cfg.CallTimeout
+	if timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+	timer := details.armAutoRemove(timeout)
+	details.goTracked("auto_remove", func() { autoRemovePeerConnection(callID, details, timer, closech) })
+}
+
+// armAutoRemove creates the call's reaper timer
+func (d *CallIDDetails) armAutoRemove(timeout time.Duration) *time.Timer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.autoRemove = time.NewTimer(timeout)
+	d.autoRemoveTimeout = timeout
+	d.expiresAt = time.Now().Add(timeout)
+	return d.autoRemove
+}
+
+var (
+	errAutoRemoveDisabled = errors.New("auto-removal is disabled for this call")
+	errAutoRemoveFired    = errors.New("call has already expired")
+)
+
+// extendAutoRemove pushes the call's auto-removal back to a full timeout
+// from now and returns the new expiry
+func (d *CallIDDetails) extendAutoRemove() (time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.autoRemove == nil {
+		return time.Time{}, errAutoRemoveDisabled
+	}
+	// Reset reports false once the timer has fired; the reaper is already
+	// tearing the call down
+	if !d.autoRemove.Reset(d.autoRemoveTimeout) {
+		return time.Time{}, errAutoRemoveFired
+	}
+	d.expiresAt = time.Now().Add(d.autoRemoveTimeout)
+	return d.expiresAt, nil
+}
 
-	return payload, nil
+// ExpiresAt is when auto-removal will reap the call, zero if never
+func (d *CallIDDetails) ExpiresAt() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expiresAt
 }
 
 // ✅ Auto remove PC after timeout
-func autoRemovePeerConnection(callID string, duration time.Duration, closech chan int) {
-	time.Sleep(duration)
+// closech is closed, never sent on, and only here: when the timeout fires,
+// before the call is torn down, so waiters see a timeout rather than a
+// plain close. A call torn down first just ends the reaper.
+func autoRemovePeerConnection(callID string, details *CallIDDetails, timer *time.Timer, closech chan struct{}) {
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-details.ctx.Done():
+		return
+	}
 	// pc, exists := callIDToOffer[callID]
+	close(closech)
+
+	// Calls with a planned duration are hung up by scheduleCallDuration instead
+	if details.PlannedDuration() > 0 {
+		return
+	}
 
 	// ActionChannels.Delete(callID)
-	if val, ok := ActionChannels.Load(callID); ok {
-		details := val.(CallIDDetails)
-		details.pc.Close()
-		ActionChannels.Delete(callID)
-		// use details.pc or details.ch
+	if teardownCall(callID, ReasonTimeout) {
 		log.Println("Auto-cleanup: Removed inactive call_id", callID)
 	}
-	closech <- 1
 }
 
 func createCallbackPayload(request OfferRequest, offer Offer, callID string) Event {
+	connection, session := callSession(offer.Type, offer.SDP)
 
+	call := Call{
+		ID:               callID,
+		From:             request.From,
+		To:               request.To, // Should be dynamic
+		MessagingProduct: request.MessagingProduct,
+		Event:            "connect",
+		Timestamp:        fmt.Sprintf("%d", time.Now().Unix()),
+		Direction:        "USER_INITIATED",
+		Connection:       connection,
+		Session:          session,
+		// Callback:   request.CallbackURL, // If empty, it's omitted due to `omitempty`
+	}
+
+	return newCallEvent(call)
+}
+
+// createAnswerCallbackPayload is the inbound counterpart of
+// createCallbackPayload, carrying our answer instead of an offer
+func createAnswerCallbackPayload(to, product, callbackData string, answer SessionDescription, callID string) Event {
+	connection, session := callSession(answer.Type, answer.SDP)
+
+	return newCallEvent(Call{
+		ID:               callID,
+		To:               to,
+		MessagingProduct: product,
+		Event:            "connect",
+		Timestamp:        fmt.Sprintf("%d", time.Now().Unix()),
+		Direction:        DirectionBusinessInitiated,
+		Connection:       connection,
+		Session:          session,
+		CallbackData:     callbackData,
+	})
+}
+
+// createTerminatePayload reports the end of a call, mirroring the
+// terminate webhook with its final status and duration
+func createTerminatePayload(record CallDetailRecord) Event {
+	status := "FAILED"
+	if record.Outcome == OutcomeCompleted {
+		status = "COMPLETED"
+	}
+
+	return newCallEvent(Call{
+		ID:        record.CallID,
+		From:      record.From,
+		To:        record.To,
+		Event:     "terminate",
+		Timestamp: fmt.Sprintf("%d", record.EndedAt.Unix()),
+		Direction: record.Direction,
+		Status:    status,
+		StartTime: fmt.Sprintf("%d", record.StartedAt.Unix()),
+		EndTime:   fmt.Sprintf("%d", record.EndedAt.Unix()),
+		Duration:  record.DurationMs / 1000,
+
+		MessagingProduct: record.MessagingProduct,
+		CallbackData:     record.CallbackData,
+	})
+}
+
+// createProgressPayload reports a step between an offer's connect and its
+// terminate, such as ringing or connected
+func createProgressPayload(callID string, details *CallIDDetails, event string) Event {
+	return newCallEvent(Call{
+		ID:        callID,
+		From:      details.from,
+		To:        details.to,
+		Event:     event,
+		Timestamp: fmt.Sprintf("%d", time.Now().Unix()),
+		Direction: details.direction,
+		Status:    strings.ToUpper(event),
+
+		MessagingProduct: details.messagingProduct,
+		CallbackData:     details.callbackData,
+	})
+}
+
+func callSession(sdpType, sdp string) (connection, session map[string]any) {
 	sdpData, err := json.Marshal(map[string]string{
-		"type": offer.Type,
-		"sdp":  offer.SDP,
+		"type": sdpType,
+		"sdp":  sdp,
 	})
 	if err != nil {
 		fmt.Println("Error marshaling SDP:", err)
 	}
 
-	connection := map[string]any{
+	connection = map[string]any{
 		"webrtc": map[string]string{
 			"sdp": string(sdpData),
 		},
 	}
 
-	// connection := map[string]any{
-	// 	"webrtc": map[string]string{
-	// 		"sdp":  offer.SDP,
-	// 		"type": offer.Type,
-	// 	},
-	// }
-
 	// Adding session field inside the connection
-	session := map[string]any{
-		"sdp":      offer.SDP,
-		"sdp_type": offer.Type,
+	session = map[string]any{
+		"sdp":      sdp,
+		"sdp_type": sdpType,
 	}
+	return connection, session
+}
 
-	call := Call{
-		ID:         callID,
-		From:       request.From,
-		To:         request.To, // Should be dynamic
-		Event:      "connect",
-		Timestamp:  fmt.Sprintf("%d", time.Now().Unix()),
-		Direction:  "USER_INITIATED",
-		Connection: connection,
-		Session:    session,
-		// Callback:   request.CallbackURL, // If empty, it's omitted due to `omitempty`
+// resolveMessagingProduct applies the --messaging-product default to a
+// request's messaging_product and, with --strict-product, checks it
+// against --allowed-products
+func resolveMessagingProduct(requested string) (string, error) {
+	cfg := currentConfig()
+	if requested == "" {
+		return cfg.MessagingProduct, nil
 	}
+	if cfg.StrictProduct && !slices.Contains(cfg.AllowedProducts, requested) {
+		return "", fmt.Errorf("messaging_product %q is not allowed (want one of %s)", requested, strings.Join(cfg.AllowedProducts, ", "))
+	}
+	return requested, nil
+}
 
+// newCallEvent wraps a single call in the webhook envelope
+func newCallEvent(call Call) Event {
 	metadata := Metadata{
 		DisplayPhoneNumber: "919999999999", // Replace dynamically if needed
 		PhoneNumberID:      "00000000000000",
@@ -237,8 +588,15 @@ func createCallbackPayload(request OfferRequest, offer Offer, callID string) Eve
 		},
 	}
 
+	product := call.MessagingProduct
+	if product == "" {
+		product = currentConfig().MessagingProduct
+	}
+
+	call.NodeID = config.NodeID
+
 	value := Value{
-		MessagingProduct: "random",
+		MessagingProduct: product,
 		Metadata:         metadata,
 		Contacts:         contacts,
 		Calls:            []Call{call},
@@ -255,40 +613,67 @@ func createCallbackPayload(request OfferRequest, offer Offer, callID string) Eve
 	}
 
 	event := Event{
-		Object: "random_business_account",
+		Object: config.WebhookObject,
 		Entry:  []Entry{entry},
 	}
 
 	return event
 }
 
-func sendCallbackAsync(callbackURL string, payload Event) {
-	go func() { // Fire and forget
-		client := &http.Client{Timeout: 10 * time.Second}
-		jsonData, _ := json.Marshal(payload)
-
-		req, err := http.NewRequest("POST", callbackURL, bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Error creating callback request: %v\n", err)
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
+// startEarlyMedia applies a pre_accept answer and loops --early-media-file
+// until the accept arrives. Media cannot flow before this: until the SUT
+// sends an SDP answer there is no ICE or DTLS to carry it. Returns nil if
+// early media could not start, leaving the call waiting for a plain accept.
+func startEarlyMedia(callID string, details *CallIDDetails, answerSDP string, audioTrack *webrtc.TrackLocalStaticSample, rtpSender *webrtc.RTPSender) chan *PrecompiledMedia {
+	// The early media file is Opus; other media kinds have nothing to play
+	if details.media != MediaOpus {
+		log.Printf("%s Ignoring pre_accept, no early media for %s calls\n", callID, details.media)
+		return nil
+	}
+	early, err := loadPrecompiledMedia(currentConfig().EarlyMediaFile)
+	if err == nil {
+		early, err = opusMedia(early)
+	}
+	if err != nil {
+		log.Printf("❌ %s Error loading early media: %v", callID, err)
+		return nil
+	}
+	details.addTimeline(TimelinePreAccepted, "")
+	if err := details.setRemoteDescription(callID, answerSDP); err != nil {
+		log.Printf("❌ Error setting remote description: %v", err)
+		return nil
+	}
+	log.Printf("%s offered -> ringing, starting early media\n", callID)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Error sending callback request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
+	switchMedia := make(chan *PrecompiledMedia, 1)
+	if err := startMedia(callID, details, early, audioTrack, rtpSender, switchMedia); err != nil {
+		log.Printf("❌ %s Error starting early media: %v\n", callID, err)
+		return nil
+	}
+	return switchMedia
+}
 
-		// body, _ := io.ReadAll(resp.Body)
-		// log.Printf("Callback response: %s\n", string(body))
-		log.Printf("Callback response status: %d\n", resp.StatusCode)
-	}()
+// startMedia begins streaming a call's audio; offered and answered calls
+// both go through it so media has the same preconditions everywhere. The
+// remote description must already be applied (the answer for an offered
+// call, the offer for an answered one), so ICE is under way, and
+// streamAudio then holds the first sample until ICE connects. Nothing is
+// sent before there is a path to send it on.
+func startMedia(callID string, details *CallIDDetails, audio *PrecompiledMedia, audioTrack *webrtc.TrackLocalStaticSample, rtpSender *webrtc.RTPSender, switchMedia <-chan *PrecompiledMedia) error {
+	if details.pc.RemoteDescription() == nil {
+		return errors.New("remote description is not set")
+	}
+	go streamAudio(details, audio, audioTrack, rtpSender, callID, switchMedia)
+	return nil
 }
 
-func streamAudio(pc *webrtc.PeerConnection, filename string, audioTrack *webrtc.TrackLocalStaticSample, rtpSender *webrtc.RTPSender, callID string) {
-	log.Println("🎵 Starting audio streaming...")
+// streamAudio sends audio once ICE connects. When switchMedia is non-nil the
+// audio is early media: it loops until the call's real audio arrives on
+// switchMedia, which then plays once from the start.
+func streamAudio(details *CallIDDetails, audio *PrecompiledMedia, audioTrack *webrtc.TrackLocalStaticSample, rtpSender *webrtc.RTPSender, callID string, switchMedia <-chan *PrecompiledMedia) {
+	cfg := currentConfig()
+	log.Printf("🎵 Starting audio streaming %s...", audio.Filename)
+	pc := details.pc
 
 	// pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 	// 	log.Printf("%s ICE Connection State has changed: %s\n", callID, connectionState.String())
@@ -300,6 +685,7 @@ func streamAudio(pc *webrtc.PeerConnection, filename string, audioTrack *webrtc.
 		log.Printf("%s ICE Connection State has changed: %s\n", callID, connectionState.String())
 		if connectionState == webrtc.ICEConnectionStateConnected {
 			log.Printf("%s ICE connection established\n", callID)
+			details.markConnected(callID)
 			iceConnected <- 1
 		}
 		if connectionState == webrtc.ICEConnectionStateDisconnected {
@@ -307,32 +693,34 @@ func streamAudio(pc *webrtc.PeerConnection, filename string, audioTrack *webrtc.
 		}
 	})
 
-	//✅ Handle RTCP (optional debugging)
-	go func() {
+	//✅ Handle RTCP, collecting Receiver Report quality for the call
+	details.goUntilClosed("rtcp_reader", func() {
 		rtcpBuf := make([]byte, 1500)
 		for {
-			_, _, rtcpErr := rtpSender.Read(rtcpBuf)
+			n, _, rtcpErr := rtpSender.Read(rtcpBuf)
 			if rtcpErr != nil {
-				log.Printf("%s Error reading RTCP: %v\n", callID, rtcpErr)
+				// Closing the PeerConnection is how a torn-down call's reader ends
+				if details.ctx.Err() == nil {
+					log.Printf("%s Error reading RTCP: %v\n", callID, rtcpErr)
+				}
 				return
 			}
+			packets, err := rtcp.Unmarshal(rtcpBuf[:n])
+			if err != nil {
+				log.Printf("%s Error parsing RTCP: %v\n", callID, err)
+				continue
+			}
+			details.recordRTCP(packets)
 		}
-	}()
-
-	go func() {
-		// ✅ Open Ogg file
-		file, err := os.Open(filename)
-		if err != nil {
-			log.Println("❌ Error opening Ogg file:", err)
-			return
-		}
-		defer file.Close()
+	})
 
-		// ✅ Create an Ogg reader
-		ogg, _, oggErr := oggreader.NewWith(file)
-		if oggErr != nil {
-			log.Println("❌ Error initializing Ogg reader:", oggErr)
-			return
+	details.goTracked("media_sender", func() {
+		// Bound how long the call may take to reach ICE-connected; a nil channel never fires
+		var connectExpired <-chan time.Time
+		if cfg.ConnectTimeout > 0 {
+			connectTimer := time.NewTimer(cfg.ConnectTimeout)
+			defer connectTimer.Stop()
+			connectExpired = connectTimer.C
 		}
 
 		select {
@@ -344,40 +732,85 @@ func streamAudio(pc *webrtc.PeerConnection, filename string, audioTrack *webrtc.
 				log.Printf("%s ICE connection disconnected, breaking loop\n", callID)
 				return
 			}
+		case <-connectExpired:
+			log.Printf("%s ICE did not connect within %s\n", callID, cfg.ConnectTimeout)
+			teardownCall(callID, ReasonConnectTimeout)
+			return
+		case <-details.ctx.Done():
+			log.Printf("%s Call ended before ICE connected, not sending audio\n", callID)
+			return
 		}
 
 		// ✅ Initialize timing
-		var lastGranule uint64
+		next := 0
+		consecutiveErrors := 0
 		ticker := time.NewTicker(20 * time.Millisecond)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				// ✅ Read Ogg packet
-				pageData, pageHeader, oggErr := ogg.ParseNextPage()
-				if errors.Is(oggErr, io.EOF) {
-					log.Printf("%s All audio pages parsed and sent\n", callID)
-					return
+				if next >= len(audio.Samples) {
+					if switchMedia == nil && !details.loopMedia {
+						log.Printf("%s All audio pages parsed and sent\n", callID)
+						return
+					}
+					// Wrap within this tick so the loop boundary has no gap;
+					// each sample keeps its own duration, so RTP timestamps
+					// run on without a jump
+					debugf("%s Looping %s", callID, audio.Filename)
+					next = 0
 				}
-				if oggErr != nil {
-					log.Printf("%s Error reading Ogg page: %v\n", callID, oggErr)
-					return
+				sample := audio.Samples[next]
+				next++
+
+				// Muted calls keep sending, but silence, so the stream has no gap
+				if details.muted.Load() {
+					sample = media.Sample{Data: silenceFrame(details.media), Duration: sample.Duration}
+				} else if config.MediaChecksum {
+					details.checkSample(callID, audio, next-1, sample.Data)
 				}
 
-				sampleCount := float64(pageHeader.GranulePosition - lastGranule)
-				lastGranule = pageHeader.GranulePosition
-				sampleDuration := time.Duration((sampleCount/48000)*1000) * time.Millisecond
-
-				if oggErr = audioTrack.WriteSample(media.Sample{Data: pageData, Duration: sampleDuration}); oggErr != nil {
-					log.Printf("%s Error writing audio sample: %v\n", callID, oggErr)
+				// Teardown may close the PeerConnection at any moment; don't write into it
+				if details.ctx.Err() != nil {
+					log.Printf("%s Call ended, audio stopped\n", callID)
 					return
 				}
+				err := audioTrack.WriteSample(sample)
+				if err == nil {
+					err = writeSimulcastLayers(details.simulcast, next-1, sample)
+				}
+				if err != nil {
+					// Lost the race with teardown: not a media error
+					if details.ctx.Err() != nil {
+						log.Printf("%s Call ended, audio stopped\n", callID)
+						return
+					}
+					details.writeErrors.Add(1)
+					metrics.WriteErrors.Add(1)
+					consecutiveErrors++
+					// A closed call will never accept another write, so stop right away
+					if errors.Is(err, io.ErrClosedPipe) || consecutiveErrors > cfg.MaxWriteErrors {
+						log.Printf("%s Error writing audio sample: %v\n", callID, err)
+						return
+					}
+					log.Printf("%s Error writing audio sample (%d in a row, tolerating): %v\n", callID, consecutiveErrors, err)
+					continue
+				}
+				consecutiveErrors = 0
+				metrics.SamplesStreamed.Add(1)
+				metrics.BytesStreamed.Add(int64(len(sample.Data)))
 
 				// if sampleDuration > 0 {
 				// 	time.Sleep(sampleDuration)
 				// }
 
-				// log.Printf("%s Sent Ogg packet of size %d bytes, duration %s\n", callID, len(pageData), sampleDuration)
+				// log.Printf("%s Sent Ogg packet of size %d bytes, duration %s\n", callID, len(sample.Data), sample.Duration)
+			case <-details.ctx.Done():
+				log.Printf("%s Call ended, audio stopped\n", callID)
+				return
+			case switched := <-switchMedia:
+				log.Printf("%s Early media done, streaming %s\n", callID, switched.Filename)
+				audio, next, switchMedia = switched, 0, nil
 			case state := <-iceConnected:
 				if state == 2 {
 					log.Printf("%s ICE connection disconnected, breaking loop\n", callID)
@@ -387,93 +820,77 @@ func streamAudio(pc *webrtc.PeerConnection, filename string, audioTrack *webrtc.
 				break
 			}
 		}
-	}()
+	})
 }
 
-func processAction(c *fiber.Ctx) error {
-	var action ActionRequest
-	if err := c.BodyParser(&action); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+// extractAcceptSDP returns the answer SDP of an accept, taken from
+// connection.webrtc.sdp or, failing that, session.sdp. Errors name the
+// exact field that is missing or mistyped.
+func extractAcceptSDP(action ActionRequest) (string, error) {
+	if raw, ok := action.Connection["webrtc"]; ok {
+		webrtcData, ok := raw.(map[string]any)
+		if !ok {
+			return "", errors.New("connection.webrtc must be an object")
+		}
+		return sdpField(webrtcData, "connection.webrtc")
 	}
-	log.Printf("📩 Parsed action request: %s %s\n", action.CallID, action.Action)
-
-	// mutex.Lock()
-	// pc, exists := callIDToOffer[action.CallID]
-	// mutex.Unlock()
-	val, ok := ActionChannels.Load(action.CallID)
 
-	if !ok {
-		// Return a proper JSON response with status, CallID, and Action details
-		return c.JSON(fiber.Map{
-			"status":  "No corresponding offer for this call_id or already closed",
-			"call_id": action.CallID,
-			"action":  action.Action,
-		})
+	if action.Session != nil {
+		sdpTypeField := currentAPIScheme().field("sdp_type")
+		if raw, ok := action.Session[sdpTypeField]; ok {
+			if sdpType, ok := raw.(string); !ok || sdpType != "answer" {
+				return "", fmt.Errorf(`session.%s must be "answer"`, sdpTypeField)
+			}
+		}
+		return sdpField(action.Session, "session")
 	}
 
-	details := val.(CallIDDetails)
-	pc := details.pc
-	if pc == nil {
-		return c.JSON(fiber.Map{
-			"status":  "No corresponding offer for this call_id or already closed",
-			"call_id": action.CallID,
-			"action":  action.Action,
-		})
-	}
+	return "", errors.New("SDP data missing: expected connection.webrtc.sdp or session.sdp")
+}
 
-	validCloseActions := map[string]bool{
-		"terminate": true,
-		"reject":    true,
-		"hangup":    true,
+func sdpField(object map[string]any, path string) (string, error) {
+	raw, ok := object["sdp"]
+	if !ok {
+		return "", fmt.Errorf("%s.sdp is missing", path)
 	}
-
-	if _, exists := validCloseActions[action.Action]; exists {
-		pc.Close()
-		// mutex.Lock()
-		// delete(callIDToOffer, action.CallID)
-		// mutex.Unlock()
-		ActionChannels.Delete(action.CallID)
+	sdp, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%s.sdp must be a string", path)
 	}
+	if sdp == "" {
+		return "", fmt.Errorf("%s.sdp must not be empty", path)
+	}
+	return sdp, nil
+}
 
-	if action.Action == "accept" {
-		var found bool
-		var sdpString string
-		if webrtcData, ok := action.Connection["webrtc"].(map[string]any); ok {
-			if sdp, ok := webrtcData["sdp"].(string); ok {
-				sdpString = sdp
-				found = true
-			}
-		}
-
-		if !found {
-			if sessionData, ok := action.Session["sdp"].(string); ok {
-				sdpString = sessionData
-				found = true
-			}
-		}
-
-		if !found {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "SDP data missing"})
-		}
-
-		// if ch, ok := ActionChannels.Load(action.CallID); ok {
-		log.Printf("📩 Sending action to channel: %s %s\n", action.CallID, action.Action)
-		// ch := details.ch
-		details.ch <- ActionData{
-			Action: action.Action,
-			Data: SessionDescription{
-				Type: "answer",
-				SDP:  sdpString,
-			},
-		}
+// errAnswerTimeout is returned by generateSDPAnswer when --answer-timeout
+// passes before the answer is ready
+var errAnswerTimeout = errors.New("answer not ready within --answer-timeout")
 
+// answerAborted says why ctx ended the answer during step
+func answerAborted(ctx context.Context, step string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w (%s took too long)", errAnswerTimeout, step)
 	}
-
-	return c.JSON(fiber.Map{"status": "Action processed successfully"})
+	return fmt.Errorf("shutting down during %s", step)
 }
 
 func generateSDPAnswer(request AnswerRequest) (AnswerResponse, error) {
-	pc, err := createPeerConnection()
+	cfg := currentConfig()
+	// ✅ Load media up front so a missing or oversized file fails this request
+	source := request.audioSource
+	if source == "" {
+		source = answerAudioSource(cfg)
+	}
+	audio, err := loadPrecompiledMedia(source)
+	if err != nil {
+		return AnswerResponse{}, err
+	}
+	if audio, err = opusMedia(audio); err != nil {
+		return AnswerResponse{}, err
+	}
+
+	pc, err := createPeerConnection(webrtcAPI)
 	if err != nil {
 		return AnswerResponse{}, err
 	}
@@ -503,9 +920,11 @@ func generateSDPAnswer(request AnswerRequest) (AnswerResponse, error) {
 	rtpSender, err := pc.AddTrack(audioTrack)
 	if err != nil {
 		log.Println("❌ Error adding audio track:", err)
+		pcBreaker.Failure()
 		pc.Close()
 		return AnswerResponse{}, err
 	}
+	pcBreaker.Success()
 	log.Println("✅ Audio track added successfully")
 
 	// Create an Answer
@@ -515,30 +934,81 @@ func generateSDPAnswer(request AnswerRequest) (AnswerResponse, error) {
 		return AnswerResponse{}, err
 	}
 
+	// --answer-timeout bounds gathering and the gather delay; the call is
+	// not registered yet, so giving up only means closing the PeerConnection
+	ctx := callsCtx
+	if cfg.AnswerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(callsCtx, cfg.AnswerTimeout)
+		defer cancel()
+	}
+
 	gatherComplete := webrtc.GatheringCompletePromise(pc)
 	if err := pc.SetLocalDescription(answer); err != nil {
 		pc.Close()
 		return AnswerResponse{}, err
 	}
-	<-gatherComplete
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		pc.Close()
+		return AnswerResponse{}, answerAborted(ctx, "ICE gathering")
+	}
+
+	// Simulate a slow callee. Only the answer timeout or a fast shutdown
+	// can cut this short; the PeerConnection is closed then.
+	gatherDelay := cfg.AnswerGatherDelay
+	if request.GatherDelayMs != nil {
+		gatherDelay = time.Duration(*request.GatherDelayMs) * time.Millisecond
+	}
+	if !sleepContext(ctx, gatherDelay) {
+		pc.Close()
+		return AnswerResponse{}, answerAborted(ctx, "the answer gather delay")
+	}
+
+	// Rewrite what we signal (see signaledSDP). pion rejects a
+	// modified SDP in SetLocalDescription, so only the signaled copy is changed.
+	answerSDP, err := signaledSDP(pc.LocalDescription().SDP)
+	if err != nil {
+		pc.Close()
+		return AnswerResponse{}, err
+	}
 
 	callID := request.CallID
 	if callID == "" {
 		callID = uuid.New().String()
 	}
+	debugSDP(callID, "remote offer", request.Session.SDP)
+	debugSDP(callID, "local answer", pc.LocalDescription().SDP)
+	if answerSDP != pc.LocalDescription().SDP {
+		debugSDP(callID, "signaled answer", answerSDP)
+	}
 
 	// mutex.Lock()
 	// callIDToOffer[callID] = pc
 	// mutex.Unlock()
-	closech := make(chan int, 1)
-	ch := make(chan ActionData, 1)
-	details := CallIDDetails{
-		pc: pc,
-		ch: ch, // buffered channel (optional)
+	closech := make(chan struct{})
+	scenario := metrics.resolveScenario(request.Metadata[MetadataScenario])
+	// Simulate many business accounts: fill in `to` from the pool when omitted
+	to := request.To
+	if to == "" {
+		to = toPool.Next()
 	}
+
+	details := newCallIDDetails(pc, DirectionBusinessInitiated, CallStateAnswered, scenario)
+	details.watchConnectionState(callID)
+	details.sender = rtpSender
+	details.to = to
+	details.priority, _ = callPriority(request.Metadata)
+	details.messagingProduct = request.MessagingProduct
+	details.callbackData = request.CallbackData
+	details.callbackURL = request.CallbackURL
+	details.loopMedia = request.Loop
+	details.recordICECredentials(callID)
 	ActionChannels.Store(callID, details)
 
-	go autoRemovePeerConnection(callID, 45*time.Second, closech)
+	startAutoRemove(callID, details, request.TimeoutSeconds, closech)
+	scheduleCallDuration(callID, details)
 
 	// go func {
 	// 	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
@@ -546,95 +1016,144 @@ func generateSDPAnswer(request AnswerRequest) (AnswerResponse, error) {
 	// 	defer cancel()
 	// }
 
-	go func() {
+	details.goTracked("answer_wait", func() {
 		// ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
 		// defer ActionChannels.Delete(callID)
 		// defer log.Printf("Leaving generate loop: %s %s\n", callID, "generateSDPAnswer")
 		// defer cancel()
 		log.Printf("📩 Starting answer audio: %s\n", callID)
-		go streamAudio(pc, "output20ms.ogg", audioTrack, rtpSender, callID)
+		if err := startMedia(callID, details, audio, audioTrack, rtpSender, nil); err != nil {
+			log.Printf("❌ %s Error starting media: %v\n", callID, err)
+		}
 		select {
 		case <-closech:
 			log.Printf("%s Timeout waiting for answer\n", callID)
+		case <-details.ctx.Done():
 		}
-	}()
+	})
 
-	return AnswerResponse{
+	response := AnswerResponse{
 		CallID: callID,
+		To:     to,
 		Answer: SessionDescription{
-			SDP:  pc.LocalDescription().SDP,
+			SDP:  answerSDP,
 			Type: pc.LocalDescription().Type.String(),
 		},
-	}, nil
-}
-
-func processAnswer(c *fiber.Ctx) error {
-	var request AnswerRequest
-	if err := c.BodyParser(&request); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
-	}
-
-	if request.Action != "connect" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid action"})
+		CallbackData: request.CallbackData,
 	}
 
-	response, err := generateSDPAnswer(request)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Error generating answer: %v", err)})
-	}
+	payload := createAnswerCallbackPayload(to, details.messagingProduct, details.callbackData, response.Answer, callID)
+	events.Publish(LifecycleEvent{
+		Type:      EventCreated,
+		CallID:    callID,
+		Direction: details.direction,
+		Scenario:  scenario,
+		Details:   details,
+		Payload:   &payload,
+	})
 
-	return c.JSON(response)
+	return response, nil
 }
 
 func main() {
 
-	port := flag.String("p", "8080", "Port to run the server on")
+	registerFlags()
 	flag.Parse()
-
-	app := fiber.New()
-
-	app.Use(logger.New(logger.Config{
-		Format: "${time} | ${status} | ${method} | ${path} | ${latency}\n",
-	}))
-
-	app.Post("/load/offer", func(c *fiber.Ctx) error {
-		var request OfferRequest
-		if err := c.BodyParser(&request); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	if len(config.AllowedProducts) == 0 {
+		config.AllowedProducts = stringList{"whatsapp"}
+	}
+	if len(config.AllowedObjects) == 0 {
+		config.AllowedObjects = stringList{"whatsapp_business_account"}
+	}
+	if err := config.validate(); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	log.SetPrefix("node=" + config.NodeID + " ")
+	logEmittedValues()
+	if err := checkUlimit(config.CheckUlimit, config.MaxCalls, config.HostOnly); err != nil {
+		log.Fatalf("❌ --check-ulimit: %v", err)
+	}
+	audio, err := loadPrecompiledMedia(config.AudioFile)
+	if err != nil {
+		log.Fatalf("❌ Invalid --audio-file: %v", err)
+	}
+	if opusUnavailable(audio) {
+		log.Printf("⚠️ --audio-file %s: %v, Opus calls stream %s instead\n", config.AudioFile, errNoOpusEncoder, fallbackAudioFile)
+	}
+	for _, entry := range config.MediaDistribution {
+		if _, err := loadPrecompiledMedia(entry.source); err != nil {
+			log.Fatalf("❌ Invalid --media-distribution entry: %v", err)
 		}
-
-		response, err := generateSDPOffer(request)
+	}
+	for _, source := range config.AnswerAudioPool {
+		if _, err := loadPrecompiledMedia(source); err != nil {
+			log.Fatalf("❌ Invalid --answer-audio-pool entry: %v", err)
+		}
+	}
+	if config.EarlyMediaFile != "" {
+		early, err := loadPrecompiledMedia(config.EarlyMediaFile)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Error generating offer: %v", err)})
+			log.Fatalf("❌ Invalid --early-media-file: %v", err)
 		}
+		if opusUnavailable(early) {
+			log.Printf("⚠️ --early-media-file %s: %v, %s plays instead\n", config.EarlyMediaFile, errNoOpusEncoder, fallbackAudioFile)
+		}
+	}
+	app, err := NewApp(config)
+	if err != nil {
+		log.Fatalf("❌ Error setting up server: %v", err)
+	}
 
-		// Return the response (it can be OfferResponse or a JSON payload)
-		return c.JSON(response)
-	})
-
-	app.Post("/load/calls", processAnswer)
-
-	app.Post("/load/action", processAction)
+	// SIGINT stops every call at once; SIGTERM (what Kubernetes sends)
+	// drains them for up to --shutdown-grace first
+	interrupted, stopInterrupt := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stopInterrupt()
+	terminated, stopTerminate := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stopTerminate()
+	callsCtx = interrupted
+
+	stopStatsLogger := func() {}
+	if config.StatsInterval > 0 {
+		stopStatsLogger = startStatsLogger(config.StatsInterval)
+	}
+	stopProbe := func() {}
+	if config.ProbeInterval > 0 {
+		stopProbe = startProbe(config.ProbeInterval, config.ProbeSLA)
+	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
 	go func() {
-		<-quit
+		select {
+		case <-interrupted.Done():
+			draining.Store(true)
+		case <-terminated.Done():
+			drainCalls(interrupted, config.ShutdownGrace)
+		}
 		log.Println("Shutting down server...")
+		stopStatsLogger()
+		stopProbe()
 		// mutex.Lock()
 		// for _, pc := range callIDToOffer {
 		// 	pc.Close()
 		// }
 		ActionChannels.Range(func(key, value any) bool {
-			details := value.(CallIDDetails)
-			details.pc.Close()
-			ActionChannels.Delete(key)
+			teardownCall(key.(string), ReasonShutdown)
 			return true
 		})
 		// mutex.Unlock()
-		os.Exit(0)
+		// Each teardown queued a terminate callback; let them go out
+		flushCallbacks(callbackFlushTimeout)
+		if config.ReportFile != "" {
+			writeReportFile(config.ReportFile)
+		}
+		if err := app.ShutdownWithTimeout(5 * time.Second); err != nil {
+			log.Printf("❌ Error shutting down server: %v\n", err)
+		}
 	}()
 
-	log.Printf("🚀 Server running on port %s", *port)
-	log.Fatal(app.Listen(":" + *port))
+	ready.Store(true)
+	log.Printf("🚀 Server running on port %s", config.Port)
+	if err := app.Listen(":" + config.Port); err != nil {
+		log.Fatal(err)
+	}
 }
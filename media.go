@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+// PrecompiledMedia is an Ogg/Opus file parsed once into ready-to-write
+// samples, so streaming a call never touches the Ogg parser.
+type PrecompiledMedia struct {
+	Filename string
+	Samples  []media.Sample
+
+	// Checksums are the CRC-32 of each sample as read, for --media-checksum
+	Checksums []uint32
+
+	// From the OpusHead header; SampleRate is the original input rate, as
+	// Opus itself always runs at 48kHz
+	Channels   uint8
+	SampleRate uint32
+	Duration   time.Duration
+
+	// pcmu is the same audio as G.711 µ-law for PCMU calls, for sources
+	// decoded from raw PCM
+	pcmu *PrecompiledMedia
+}
+
+// mediaCache holds one *PrecompiledMedia per file or URL, shared by all
+// calls. Sample data is never modified after loading.
+var mediaCache sync.Map
+
+// allowedMediaTypes are the Content-Types accepted from an http(s) source
+var allowedMediaTypes = map[string]bool{
+	"audio/ogg":                true,
+	"audio/opus":               true,
+	"application/ogg":          true,
+	"application/octet-stream": true,
+}
+
+var mediaHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func isRemoteMedia(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// loadPrecompiledMedia returns the parsed samples for a local file or an
+// http(s) URL, loading and caching them on first use.
+func loadPrecompiledMedia(source string) (*PrecompiledMedia, error) {
+	if cached, ok := mediaCache.Load(source); ok {
+		return cached.(*PrecompiledMedia), nil
+	}
+
+	var compiled *PrecompiledMedia
+	var err error
+	switch {
+	case isPCMSource(source) && isRemoteMedia(source):
+		return nil, fmt.Errorf("%s: WAV/PCM sources must be local files", source)
+	case isPCMSource(source):
+		compiled, err = precompilePCMFile(source)
+	case isRemoteMedia(source):
+		compiled, err = fetchRemoteMedia(source)
+	default:
+		compiled, err = precompileOggFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _ := mediaCache.LoadOrStore(source, compiled)
+	return actual.(*PrecompiledMedia), nil
+}
+
+// requestAudioSource resolves a request's audio_file, a path relative to
+// --audio-dir, and loads it so a missing or malformed file, or one that
+// can't be streamed on a call of the given media kind, fails the request.
+// Names that would leave the directory, directly or through a symlink, are
+// refused.
+func requestAudioSource(dir, name, kind string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("audio_file needs --audio-dir on the server")
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("audio_file %q must be a relative path inside --audio-dir", name)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("audio_file: %w", err)
+	}
+	source, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", fmt.Errorf("audio_file %q not found in --audio-dir", name)
+	}
+	if rel, err := filepath.Rel(root, source); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("audio_file %q must be a relative path inside --audio-dir", name)
+	}
+	audio, err := loadPrecompiledMedia(source)
+	if err != nil {
+		return "", fmt.Errorf("audio_file %q is not a readable Ogg/Opus, WAV or PCM file: %w", name, err)
+	}
+	if kind != MediaPCMU && opusUnavailable(audio) {
+		return "", fmt.Errorf("audio_file %q is raw PCM and %v, so it can only be streamed on a pcmu call", name, errNoOpusEncoder)
+	}
+	return source, nil
+}
+
+func precompileOggFile(filename string) (*PrecompiledMedia, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening Ogg file: %w", err)
+	}
+	defer file.Close()
+
+	return precompileOgg(filename, file)
+}
+
+// fetchRemoteMedia downloads an Ogg file, refusing anything that is not
+// audio or is larger than --max-media-bytes before it is buffered
+func fetchRemoteMedia(url string) (*PrecompiledMedia, error) {
+	cfg := currentConfig()
+	resp, err := mediaHTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching media %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching media %s: unexpected status %d", url, resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !allowedMediaTypes[mediaType] {
+		return nil, fmt.Errorf("fetching media %s: unsupported Content-Type %q", url, resp.Header.Get("Content-Type"))
+	}
+
+	if resp.ContentLength > cfg.MaxMediaBytes {
+		return nil, fmt.Errorf("fetching media %s: %d bytes exceeds limit of %d", url, resp.ContentLength, cfg.MaxMediaBytes)
+	}
+
+	// Read one byte past the limit to detect oversized bodies without a Content-Length
+	data, err := io.ReadAll(io.LimitReader(resp.Body, cfg.MaxMediaBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching media %s: %w", url, err)
+	}
+	if int64(len(data)) > cfg.MaxMediaBytes {
+		return nil, fmt.Errorf("fetching media %s: body exceeds limit of %d bytes", url, cfg.MaxMediaBytes)
+	}
+
+	return precompileOgg(url, bytes.NewReader(data))
+}
+
+// maxOggPageDuration bounds the audio a single page may carry; RFC 7845
+// pages are normally well under a second
+const maxOggPageDuration = time.Second
+
+// precompileOgg parses an Ogg/Opus stream, rejecting inputs whose header
+// or pages look wrong rather than streaming them: oversized pages (see
+// --max-ogg-page-bytes), granule positions that run backwards or jump too
+// far, and files with no audio at all.
+func precompileOgg(name string, r io.Reader) (*PrecompiledMedia, error) {
+	maxPageBytes := currentConfig().MaxOggPageBytes
+	buffered := bufio.NewReader(r)
+	if err := validateOggOpus(name, buffered); err != nil {
+		return nil, err
+	}
+	ogg, header, err := oggreader.NewWith(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("initializing Ogg reader: %w", err)
+	}
+	if header.Channels < 1 || header.Channels > 2 {
+		return nil, fmt.Errorf("%s: unsupported channel count %d", name, header.Channels)
+	}
+
+	compiled := &PrecompiledMedia{Filename: name, Channels: header.Channels, SampleRate: header.SampleRate}
+	var lastGranule uint64
+	for page := 1; ; page++ {
+		pageData, pageHeader, err := ogg.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading Ogg page: %w", err)
+		}
+
+		if len(pageData) > maxPageBytes {
+			return nil, fmt.Errorf("%s: page %d is %d bytes, over the %d byte limit", name, page, len(pageData), maxPageBytes)
+		}
+		if pageHeader.GranulePosition < lastGranule {
+			return nil, fmt.Errorf("%s: page %d granule position goes backwards", name, page)
+		}
+
+		sampleCount := float64(pageHeader.GranulePosition - lastGranule)
+		lastGranule = pageHeader.GranulePosition
+		sampleDuration := time.Duration((sampleCount/48000)*1000) * time.Millisecond
+		if sampleDuration > maxOggPageDuration {
+			return nil, fmt.Errorf("%s: page %d carries %s of audio, over %s", name, page, sampleDuration, maxOggPageDuration)
+		}
+
+		compiled.Samples = append(compiled.Samples, media.Sample{Data: pageData, Duration: sampleDuration})
+		compiled.Checksums = append(compiled.Checksums, crc32.ChecksumIEEE(pageData))
+	}
+	if lastGranule == 0 {
+		return nil, fmt.Errorf("%s: no audio pages", name)
+	}
+	if lastGranule > uint64(header.PreSkip) {
+		compiled.Duration = time.Duration(lastGranule-uint64(header.PreSkip)) * time.Second / 48000
+	}
+	log.Printf("🎵 Loaded %s: Opus, %d channel(s), %d Hz input, %s in %d pages\n",
+		name, compiled.Channels, compiled.SampleRate, compiled.Duration.Round(time.Millisecond), len(compiled.Samples))
+	return compiled, nil
+}
+
+// oggCodecSignatures identify the codec of an Ogg stream from the start
+// of its first packet, so a file in the wrong format gets a clear error
+var oggCodecSignatures = []struct {
+	signature string
+	codec     string
+}{
+	{"OpusHead", "Opus"},
+	{"\x01vorbis", "Vorbis"},
+	{"Speex   ", "Speex"},
+	{"\x7fFLAC", "FLAC"},
+	{"\x80theora", "Theora"},
+}
+
+// validateOggOpus checks that r holds an Ogg stream whose first packet is
+// an Opus identification header, without consuming any of it
+func validateOggOpus(name string, r *bufio.Reader) error {
+	// The first page's header is 27 bytes plus a segment table of at most
+	// 255 entries, and the codec's signature starts its payload
+	head, _ := r.Peek(27 + 255 + 8)
+	if !bytes.HasPrefix(head, []byte("OggS")) {
+		return fmt.Errorf("%s: not an Ogg file", name)
+	}
+	if len(head) < 27 {
+		return fmt.Errorf("%s: truncated Ogg page header", name)
+	}
+	payloadStart := 27 + int(head[26])
+	if len(head) <= payloadStart {
+		return fmt.Errorf("%s: truncated first Ogg page", name)
+	}
+	payload := head[payloadStart:]
+	for _, candidate := range oggCodecSignatures {
+		if bytes.HasPrefix(payload, []byte(candidate.signature)) {
+			if candidate.codec != "Opus" {
+				return fmt.Errorf("%s: Ogg file contains %s, only Opus is supported", name, candidate.codec)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: Ogg file does not contain Opus audio", name)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+const benchmarkAudioFile = "output20ms.ogg"
+
+// Starting a call's media from the shared precompiled samples
+func BenchmarkPrecompiledMedia(b *testing.B) {
+	newTestApp(b, nil)
+	if _, err := loadPrecompiledMedia(benchmarkAudioFile); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		audio, err := loadPrecompiledMedia(benchmarkAudioFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var bytes int
+		for _, sample := range audio.Samples {
+			bytes += len(sample.Data)
+		}
+		if bytes == 0 {
+			b.Fatal("no audio")
+		}
+	}
+}
+
+// Opening and parsing the Ogg file per call, as before precompiling
+func BenchmarkLiveOggParsing(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		file, err := os.Open(benchmarkAudioFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ogg, _, err := oggreader.NewWith(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var bytes int
+		for {
+			page, _, err := ogg.ParseNextPage()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			bytes += len(page)
+		}
+		file.Close()
+		if bytes == 0 {
+			b.Fatal("no audio")
+		}
+	}
+}
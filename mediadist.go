@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+type mediaDistributionEntry struct {
+	source string
+	weight int
+}
+
+// mediaDistribution is a flag.Value for weighted audio sources like
+// "greeting.ogg:50,hold.ogg:30,silence.ogg:20". The weight follows the
+// last colon, so URLs can be used as sources.
+type mediaDistribution []mediaDistributionEntry
+
+func (d *mediaDistribution) String() string {
+	parts := make([]string, len(*d))
+	for i, entry := range *d {
+		parts[i] = fmt.Sprintf("%s:%d", entry.source, entry.weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (d *mediaDistribution) Set(value string) error {
+	var dist mediaDistribution
+	total := 0
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, ":")
+		if i <= 0 {
+			return fmt.Errorf("media distribution entry %q must be file:weight", item)
+		}
+		source := item[:i]
+		weight, err := strconv.Atoi(item[i+1:])
+		if err != nil || weight < 0 {
+			return fmt.Errorf("media distribution weight for %s must be a non-negative integer", source)
+		}
+		dist = append(dist, mediaDistributionEntry{source: source, weight: weight})
+		total += weight
+	}
+	if len(dist) > 0 && total == 0 {
+		return fmt.Errorf("media distribution weights must not all be 0")
+	}
+	*d = dist
+	return nil
+}
+
+// pick samples an audio source by weight, or returns "" when no
+// distribution is configured
+func (d mediaDistribution) pick() string {
+	total := 0
+	for _, entry := range d {
+		total += entry.weight
+	}
+	if total == 0 {
+		return ""
+	}
+	n := rand.N(total)
+	for _, entry := range d {
+		if n < entry.weight {
+			return entry.source
+		}
+		n -= entry.weight
+	}
+	return ""
+}
+
+// defaultAudioSource is the file streamed on a call that names none:
+// a draw from --media-distribution, recorded in the stats, or --audio-file
+func defaultAudioSource(cfg Config) string {
+	if source := cfg.MediaDistribution.pick(); source != "" {
+		metrics.recordMediaFile(source)
+		return source
+	}
+	return cfg.AudioFile
+}
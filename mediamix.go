@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// Call media types an offer can be created with
+const (
+	MediaOpus  = "opus"  // Opus audio from --audio-file
+	MediaVideo = "video" // Opus audio plus a negotiated VP8 video track
+	MediaPCMU  = "pcmu"  // G.711 µ-law audio carrying silence
+)
+
+func validateMediaKind(kind string) error {
+	switch kind {
+	case MediaOpus, MediaVideo, MediaPCMU:
+		return nil
+	}
+	return fmt.Errorf("invalid media %q (want %s, %s or %s)", kind, MediaOpus, MediaVideo, MediaPCMU)
+}
+
+type mediaMixEntry struct {
+	kind   string
+	weight int
+}
+
+// mediaMix is a flag.Value for weighted media specs like "opus:70,video:20,pcmu:10"
+type mediaMix []mediaMixEntry
+
+func (m *mediaMix) String() string {
+	parts := make([]string, len(*m))
+	for i, entry := range *m {
+		parts[i] = fmt.Sprintf("%s:%d", entry.kind, entry.weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *mediaMix) Set(value string) error {
+	var mix mediaMix
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kind, weightStr, ok := strings.Cut(item, ":")
+		if !ok {
+			return fmt.Errorf("media mix entry %q must be kind:weight", item)
+		}
+		if err := validateMediaKind(kind); err != nil {
+			return err
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 0 {
+			return fmt.Errorf("media mix weight for %s must be a non-negative integer", kind)
+		}
+		mix = append(mix, mediaMixEntry{kind: kind, weight: weight})
+	}
+	*m = mix
+	return nil
+}
+
+// pick samples a media type by weight, falling back to Opus when no mix is configured
+func (m mediaMix) pick() string {
+	total := 0
+	for _, entry := range m {
+		total += entry.weight
+	}
+	if total == 0 {
+		return MediaOpus
+	}
+	n := rand.N(total)
+	for _, entry := range m {
+		if n < entry.weight {
+			return entry.kind
+		}
+		n -= entry.weight
+	}
+	return MediaOpus
+}
+
+// audioCodec is the track codec used for kind
+func audioCodec(kind string) webrtc.RTPCodecCapability {
+	if kind == MediaPCMU {
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}
+	}
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}
+}
+
+// pcmuSilence is 20ms of µ-law silence, shared by every PCMU sample
+var pcmuSilence = func() []byte {
+	frame := make([]byte, 160)
+	for i := range frame {
+		frame[i] = 0xFF
+	}
+	return frame
+}()
+
+// opusSilence is a single 20ms Opus silence frame (TOC 0xF8, CELT fullband)
+var opusSilence = []byte{0xF8, 0xFF, 0xFE}
+
+// silenceFrame is what a muted call sends in place of each sample
+func silenceFrame(kind string) []byte {
+	if kind == MediaPCMU {
+		return pcmuSilence
+	}
+	return opusSilence
+}
+
+// pcmuMedia returns audio transcoded to µ-law when it came from raw PCM,
+// or else silence lasting as long as audio, so PCMU calls hold the line
+// for the same time as Opus ones
+func pcmuMedia(audio *PrecompiledMedia) *PrecompiledMedia {
+	if audio.pcmu != nil {
+		return audio.pcmu
+	}
+	samples := make([]media.Sample, len(audio.Samples))
+	checksums := make([]uint32, len(audio.Samples))
+	for i := range samples {
+		samples[i] = media.Sample{Data: pcmuSilence, Duration: 20 * time.Millisecond}
+		checksums[i] = crc32.ChecksumIEEE(pcmuSilence)
+	}
+	return &PrecompiledMedia{Filename: "pcmu-silence", Samples: samples, Checksums: checksums}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Metrics struct {
+	OffersCreated   atomic.Int64
+	AnswersCreated  atomic.Int64
+	HalfOpenCalls   atomic.Int64
+	OfferFailures   atomic.Int64
+	AnswerFailures  atomic.Int64
+	AnswersRejected atomic.Int64
+	CallGoroutines  atomic.Int64
+	GoroutineLeaks  atomic.Int64
+	GlareCollisions atomic.Int64
+	BreakerTrips    atomic.Int64
+	ShedRequests    atomic.Int64
+	Evictions       atomic.Int64
+	ExpiredAccepts  atomic.Int64
+
+	// Run totals for the report; see report.go
+	PeakActiveCalls atomic.Int64
+	BytesStreamed   atomic.Int64
+	SamplesStreamed atomic.Int64
+	WriteErrors     atomic.Int64
+	MediaMismatches atomic.Int64
+
+	mu         sync.Mutex
+	teardowns  map[string]int64
+	answerWait map[string]*Histogram
+	scenarios  map[string]*ScenarioMetrics
+	media      map[string]int64
+	resolved   map[string]int64
+	callbacks  map[string]*CallbackCounts
+	mediaFiles map[string]int64
+
+	connectionStates map[string]int64
+}
+
+var metrics = &Metrics{
+	teardowns:  map[string]int64{},
+	answerWait: map[string]*Histogram{},
+	scenarios:  map[string]*ScenarioMetrics{},
+	media:      map[string]int64{},
+	resolved:   map[string]int64{},
+	callbacks:  map[string]*CallbackCounts{},
+	mediaFiles: map[string]int64{},
+
+	connectionStates: map[string]int64{},
+}
+
+const (
+	MetadataScenario = "scenario"
+
+	DefaultScenario  = "default"
+	OverflowScenario = "other"
+)
+
+// ScenarioMetrics are the per-scenario series exported on /metrics
+type ScenarioMetrics struct {
+	Offers        atomic.Int64
+	OfferFailures atomic.Int64
+	Answers       atomic.Int64
+	OfferLatency  *Histogram
+
+	mu        sync.Mutex
+	teardowns map[string]int64
+}
+
+// resolveScenario maps a requested scenario name to the label actually used,
+// folding new names into OverflowScenario once --max-scenarios is reached.
+func (m *Metrics) resolveScenario(name string) string {
+	if name == "" {
+		name = DefaultScenario
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.scenarios[name]; ok {
+		return name
+	}
+	if len(m.scenarios) >= config.MaxScenarios {
+		name = OverflowScenario
+		if _, ok := m.scenarios[name]; ok {
+			return name
+		}
+	}
+	m.scenarios[name] = &ScenarioMetrics{
+		OfferLatency: newHistogram(defaultBuckets),
+		teardowns:    map[string]int64{},
+	}
+	return name
+}
+
+func (m *Metrics) scenario(label string) *ScenarioMetrics {
+	label = m.resolveScenario(label)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.scenarios[label]
+}
+
+// How an offer's wait for an accept was resolved
+const (
+	AnswerWaitAccepted = "accepted"
+	AnswerWaitTimeout  = "timeout"
+	AnswerWaitClosed   = "closed"
+)
+
+// defaultBuckets are histogram upper bounds in seconds
+var defaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram is a fixed-bucket, Prometheus-style histogram of durations
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	counts  []int64
+	count   int64
+	sumSecs float64
+	maxSecs float64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	secs := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if secs <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sumSecs += secs
+	h.maxSecs = max(h.maxSecs, secs)
+}
+
+type HistogramSnapshot struct {
+	Count      int64            `json:"count"`
+	SumSeconds float64          `json:"sum_seconds"`
+	Buckets    map[string]int64 `json:"buckets"` // cumulative, keyed by upper bound
+}
+
+func (h *Histogram) snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make(map[string]int64, len(h.bounds)+1)
+	for i, bound := range h.bounds {
+		buckets[strconv.FormatFloat(bound, 'g', -1, 64)] = h.counts[i]
+	}
+	buckets["+Inf"] = h.count
+	return HistogramSnapshot{Count: h.count, SumSeconds: h.sumSecs, Buckets: buckets}
+}
+
+func (m *Metrics) observeAnswerWait(outcome string, d time.Duration) {
+	m.mu.Lock()
+	h, ok := m.answerWait[outcome]
+	if !ok {
+		h = newHistogram(defaultBuckets)
+		m.answerWait[outcome] = h
+	}
+	m.mu.Unlock()
+	h.Observe(d)
+}
+
+func (m *Metrics) recordTeardown(scenario string, reason string) {
+	m.mu.Lock()
+	m.teardowns[reason]++
+	m.mu.Unlock()
+
+	sm := m.scenario(scenario)
+	sm.mu.Lock()
+	sm.teardowns[reason]++
+	sm.mu.Unlock()
+}
+
+func (m *Metrics) recordMedia(kind string) {
+	m.mu.Lock()
+	m.media[kind]++
+	m.mu.Unlock()
+}
+
+// recordMediaFile counts a call streaming source from --media-distribution
+func (m *Metrics) recordMediaFile(source string) {
+	m.mu.Lock()
+	m.mediaFiles[source]++
+	m.mu.Unlock()
+}
+
+// recordConnectionState counts PeerConnection transitions into state
+func (m *Metrics) recordConnectionState(state string) {
+	m.mu.Lock()
+	m.connectionStates[state]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) recordAutoResolve(outcome string) {
+	m.mu.Lock()
+	m.resolved[outcome]++
+	m.mu.Unlock()
+}
+
+// CallbackCounts are the delivery outcomes of callbacks to one URL
+type CallbackCounts struct {
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`
+}
+
+// OtherCallbackURL labels callbacks to URLs outside --callback-urls, so
+// per-request URLs cannot grow the metrics without bound
+const OtherCallbackURL = "other"
+
+func (m *Metrics) recordCallback(callbackURL, outcome string) {
+	if outcome == CallbackCancelled {
+		return
+	}
+	if !config.CallbackURLs.contains(callbackURL) {
+		callbackURL = OtherCallbackURL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts, ok := m.callbacks[callbackURL]
+	if !ok {
+		counts = &CallbackCounts{}
+		m.callbacks[callbackURL] = counts
+	}
+	if outcome == CallbackDelivered {
+		counts.Delivered++
+	} else {
+		counts.Failed++
+	}
+}
+
+// MediaShare is how many offers used a media type and their share of all offers
+type MediaShare struct {
+	Count   int64   `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+// mediaShares turns counts into each one's share of their total
+func mediaShares(counts map[string]int64) map[string]MediaShare {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	shares := make(map[string]MediaShare, len(counts))
+	for key, count := range counts {
+		shares[key] = MediaShare{Count: count, Percent: float64(count) / float64(total) * 100}
+	}
+	return shares
+}
+
+type StatsResponse struct {
+	NodeID          string           `json:"node_id"`
+	ActiveCalls     int64            `json:"active_calls"`
+	OffersCreated   int64            `json:"offers_created"`
+	AnswersCreated  int64            `json:"answers_created"`
+	HalfOpenCalls   int64            `json:"half_open_calls"`
+	OfferFailures   int64            `json:"offer_failures"`
+	AnswerFailures  int64            `json:"answer_failures"`
+	AnswersRejected int64            `json:"answers_rejected"`
+	CallGoroutines  int64            `json:"call_goroutines"`
+	GoroutineLeaks  int64            `json:"goroutine_leaks"`
+	GlareCollisions int64            `json:"glare_collisions"`
+	BreakerState    string           `json:"breaker_state"`
+	BreakerTrips    int64            `json:"breaker_trips"`
+	ShedRequests    int64            `json:"shed_requests"`
+	Evictions       int64            `json:"evictions"`
+	MediaMismatches int64            `json:"media_mismatches"`
+	ExpiredAccepts  int64            `json:"expired_accepts"`
+	Teardowns       map[string]int64 `json:"teardowns"`
+
+	AnswerWait   map[string]HistogramSnapshot `json:"answer_wait_seconds"`
+	MediaMix     map[string]MediaShare        `json:"media_mix"`
+	AutoResolved map[string]int64             `json:"auto_resolved,omitempty"`
+	Callbacks    map[string]CallbackCounts    `json:"callbacks,omitempty"`
+	Probe        *ProbeSnapshot               `json:"probe,omitempty"`
+
+	ConnectionStates map[string]int64 `json:"connection_states"`
+
+	// Calls per --media-distribution file, as actually drawn
+	MediaDistribution map[string]MediaShare `json:"media_distribution,omitempty"`
+}
+
+func (m *Metrics) snapshot() StatsResponse {
+	var active int64
+	ActionChannels.Range(func(_, _ any) bool {
+		active++
+		return true
+	})
+
+	m.mu.Lock()
+	teardowns := make(map[string]int64, len(m.teardowns))
+	for reason, count := range m.teardowns {
+		teardowns[reason] = count
+	}
+	answerWait := make(map[string]*Histogram, len(m.answerWait))
+	for outcome, h := range m.answerWait {
+		answerWait[outcome] = h
+	}
+	mediaMix := mediaShares(m.media)
+	mediaDistribution := mediaShares(m.mediaFiles)
+	autoResolved := make(map[string]int64, len(m.resolved))
+	for outcome, count := range m.resolved {
+		autoResolved[outcome] = count
+	}
+	callbacks := make(map[string]CallbackCounts, len(m.callbacks))
+	for callbackURL, counts := range m.callbacks {
+		callbacks[callbackURL] = *counts
+	}
+	connectionStates := make(map[string]int64, len(m.connectionStates))
+	for state, count := range m.connectionStates {
+		connectionStates[state] = count
+	}
+	m.mu.Unlock()
+
+	answerWaitSnapshots := make(map[string]HistogramSnapshot, len(answerWait))
+	for outcome, h := range answerWait {
+		answerWaitSnapshots[outcome] = h.snapshot()
+	}
+
+	return StatsResponse{
+		NodeID:          config.NodeID,
+		ActiveCalls:     active,
+		OffersCreated:   m.OffersCreated.Load(),
+		AnswersCreated:  m.AnswersCreated.Load(),
+		HalfOpenCalls:   m.HalfOpenCalls.Load(),
+		OfferFailures:   m.OfferFailures.Load(),
+		AnswerFailures:  m.AnswerFailures.Load(),
+		AnswersRejected: m.AnswersRejected.Load(),
+		CallGoroutines:  m.CallGoroutines.Load(),
+		GoroutineLeaks:  m.GoroutineLeaks.Load(),
+		GlareCollisions: m.GlareCollisions.Load(),
+		BreakerState:    pcBreaker.State(),
+		BreakerTrips:    m.BreakerTrips.Load(),
+		ShedRequests:    m.ShedRequests.Load(),
+		Evictions:       m.Evictions.Load(),
+		MediaMismatches: m.MediaMismatches.Load(),
+		ExpiredAccepts:  m.ExpiredAccepts.Load(),
+		Teardowns:       teardowns,
+		AnswerWait:      answerWaitSnapshots,
+		MediaMix:        mediaMix,
+		AutoResolved:    autoResolved,
+		Callbacks:       callbacks,
+		Probe:           probe.snapshot(),
+
+		ConnectionStates: connectionStates,
+
+		MediaDistribution: mediaDistribution,
+	}
+}
+
+func getStats(c *fiber.Ctx) error {
+	return c.JSON(metrics.snapshot())
+}
+
+// startStatsLogger logs a one-line summary every interval until the
+// returned stop function is called
+func startStatsLogger(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := metrics.snapshot()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				snap := metrics.snapshot()
+				perSec := func(now, before int64) float64 {
+					return float64(now-before) / interval.Seconds()
+				}
+				log.Printf("📊 active=%d offers/s=%.1f answers/s=%.1f errors/s=%.1f offers=%d answers=%d errors=%d half_open=%d\n",
+					snap.ActiveCalls,
+					perSec(snap.OffersCreated, last.OffersCreated),
+					perSec(snap.AnswersCreated, last.AnswersCreated),
+					perSec(snap.OfferFailures+snap.AnswerFailures, last.OfferFailures+last.AnswerFailures),
+					snap.OffersCreated,
+					snap.AnswersCreated,
+					snap.OfferFailures+snap.AnswerFailures,
+					snap.HalfOpenCalls,
+				)
+				last = snap
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+type CallStatsResponse struct {
+	CallID    string          `json:"call_id"`
+	Direction string          `json:"direction"`
+	State     CallState       `json:"state"`
+	Scenario  string          `json:"scenario"`
+	Priority  int             `json:"priority"`
+	Media     string          `json:"media,omitempty"`
+	AgeMs     int64           `json:"age_ms"`
+	RTCP      RTCPQuality     `json:"rtcp"`
+	Timeline  []TimelineEvent `json:"timeline"`
+
+	Goroutines  map[string]int `json:"goroutines"`
+	WriteErrors int64          `json:"write_errors"`
+
+	ICECredentials *ICECredentials `json:"ice_credentials,omitempty"`
+	CallbackData   string          `json:"biz_opaque_callback_data,omitempty"`
+	ExpiresAt      *time.Time      `json:"expires_at,omitempty"`
+
+	Samples []StatsSample `json:"samples,omitempty"`
+
+	ConnectionState string `json:"connection_state"`
+
+	MediaIntegrity *MediaIntegrity `json:"media_integrity,omitempty"`
+}
+
+// ActiveCall is one registered call in the GET /load/calls listing
+type ActiveCall struct {
+	CallID             string    `json:"call_id"`
+	Direction          string    `json:"direction"`
+	State              CallState `json:"state"`
+	ConnectionState    string    `json:"connection_state"`
+	ICEConnectionState string    `json:"ice_connection_state"`
+	CreatedAt          time.Time `json:"created_at"`
+	AgeMs              int64     `json:"age_ms"`
+}
+
+// listCalls reports every registered call, youngest first. Calls added or
+// torn down while the registry is ranged over may or may not be listed.
+func listCalls(c *fiber.Ctx) error {
+	now := time.Now()
+	calls := []ActiveCall{}
+	ActionChannels.Range(func(key, val any) bool {
+		details := val.(*CallIDDetails)
+		calls = append(calls, ActiveCall{
+			CallID:             key.(string),
+			Direction:          details.direction,
+			State:              details.State(),
+			ConnectionState:    details.pc.ConnectionState().String(),
+			ICEConnectionState: details.pc.ICEConnectionState().String(),
+			CreatedAt:          details.createdAt,
+			AgeMs:              now.Sub(details.createdAt).Milliseconds(),
+		})
+		return true
+	})
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].CreatedAt.After(calls[j].CreatedAt)
+	})
+	return c.JSON(calls)
+}
+
+func getCallStats(c *fiber.Ctx) error {
+	callID := c.Params("call_id")
+	val, ok := ActionChannels.Load(callID)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No active call for this call_id", "call_id": callID})
+	}
+	return c.JSON(newCallStatsResponse(callID, val.(*CallIDDetails)))
+}
+
+func newCallStatsResponse(callID string, details *CallIDDetails) CallStatsResponse {
+	var expiresAt *time.Time
+	if at := details.ExpiresAt(); !at.IsZero() {
+		expiresAt = &at
+	}
+	return CallStatsResponse{
+		CallID:    callID,
+		Direction: details.direction,
+		State:     details.State(),
+		Scenario:  details.scenario,
+		Priority:  details.priority,
+		Media:     details.media,
+		AgeMs:     time.Since(details.createdAt).Milliseconds(),
+		RTCP:      details.Quality(),
+		Timeline:  details.Timeline(),
+
+		Goroutines:  details.runningGoroutines(),
+		WriteErrors: details.writeErrors.Load(),
+
+		ICECredentials: details.iceCredentials,
+		CallbackData:   details.callbackData,
+		ExpiresAt:      expiresAt,
+
+		Samples: details.Samples(),
+
+		ConnectionState: details.ConnectionState().String(),
+
+		MediaIntegrity: details.Integrity(),
+	}
+}
+
+// getMetrics renders the per-scenario series in the Prometheus text format
+func getMetrics(c *fiber.Ctx) error {
+	metrics.mu.Lock()
+	labels := make([]string, 0, len(metrics.scenarios))
+	scenarios := make(map[string]*ScenarioMetrics, len(metrics.scenarios))
+	for label, sm := range metrics.scenarios {
+		labels = append(labels, label)
+		scenarios[label] = sm
+	}
+	metrics.mu.Unlock()
+	sort.Strings(labels)
+
+	var b strings.Builder
+	writeCounterFamily(&b, "wa_load_offers_total", "Offers created.", labels, func(label string) int64 {
+		return scenarios[label].Offers.Load()
+	})
+	writeCounterFamily(&b, "wa_load_offer_failures_total", "Offers that failed to be created.", labels, func(label string) int64 {
+		return scenarios[label].OfferFailures.Load()
+	})
+	writeCounterFamily(&b, "wa_load_answers_total", "Answers created for inbound calls.", labels, func(label string) int64 {
+		return scenarios[label].Answers.Load()
+	})
+
+	b.WriteString("# HELP wa_load_teardowns_total Calls torn down, by reason.\n")
+	b.WriteString("# TYPE wa_load_teardowns_total counter\n")
+	for _, label := range labels {
+		sm := scenarios[label]
+		sm.mu.Lock()
+		reasons := make([]string, 0, len(sm.teardowns))
+		for reason := range sm.teardowns {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(&b, "wa_load_teardowns_total{scenario=%s,reason=%s} %d\n", labelValue(label), labelValue(reason), sm.teardowns[reason])
+		}
+		sm.mu.Unlock()
+	}
+
+	b.WriteString("# HELP wa_load_offer_duration_seconds Time taken to create an offer.\n")
+	b.WriteString("# TYPE wa_load_offer_duration_seconds histogram\n")
+	for _, label := range labels {
+		snap := scenarios[label].OfferLatency.snapshot()
+		for _, bound := range defaultBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(&b, "wa_load_offer_duration_seconds_bucket{scenario=%s,le=%s} %d\n", labelValue(label), labelValue(le), snap.Buckets[le])
+		}
+		fmt.Fprintf(&b, "wa_load_offer_duration_seconds_bucket{scenario=%s,le=\"+Inf\"} %d\n", labelValue(label), snap.Count)
+		fmt.Fprintf(&b, "wa_load_offer_duration_seconds_sum{scenario=%s} %g\n", labelValue(label), snap.SumSeconds)
+		fmt.Fprintf(&b, "wa_load_offer_duration_seconds_count{scenario=%s} %d\n", labelValue(label), snap.Count)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+	return c.SendString(b.String())
+}
+
+func writeCounterFamily(b *strings.Builder, name, help string, labels []string, value func(label string) int64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	for _, label := range labels {
+		fmt.Fprintf(b, "%s{scenario=%s} %d\n", name, labelValue(label), value(label))
+	}
+}
+
+// labelEscaper escapes a label value as the Prometheus text format does:
+// only backslash, double quote and newline; other characters stay as UTF-8
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue is value quoted for use in a Prometheus label
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
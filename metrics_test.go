@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"checkout", `"checkout"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\calls`, `"C:\\calls"`},
+		{"two\nlines", `"two\nlines"`},
+		{"café\tβ", "\"café\tβ\""},
+	}
+	for _, tt := range tests {
+		if got := labelValue(tt.value); got != tt.want {
+			t.Errorf("labelValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+// Scenario labels come from clients, so /metrics must escape them the
+// way Prometheus parses them rather than as Go strings
+func TestMetricsScenarioLabels(t *testing.T) {
+	app := newTestApp(t, nil)
+	scenario := "café \"β\"\nnext\\"
+	if resp, _ := offer(t, app, map[string]any{"metadata": map[string]string{MetadataScenario: scenario}}); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("offer = %d, want 200", resp.StatusCode)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	// Metrics are process-wide, so only the series is checked, not its count
+	want := `wa_load_offers_total{scenario="café \"β\"\nnext\\"} `
+	if !strings.Contains(string(body), "\n"+want) {
+		t.Errorf("/metrics has no %s line:\n%s", want, body)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/webrtc/v4"
 )
@@ -13,9 +16,100 @@ type ActionData struct {
 
 var ActionChannels = sync.Map{}
 
+// CallIDDetails is one registered call. It is shared by the HTTP handlers,
+// the call's own goroutines and teardown, so its fields fall in three groups:
+//
+//   - plain fields, pc through cancel, are set before the call is stored in
+//     ActionChannels and never written again, so they are read without locking
+//   - atomics, channels and sync.Once are safe as they are
+//   - everything after mu is guarded by it and only touched through methods
 type CallIDDetails struct {
-	pc *webrtc.PeerConnection
-	ch chan ActionData
+	pc        *webrtc.PeerConnection
+	sender    *webrtc.RTPSender
+	ch        chan ActionData
+	direction string
+	from      string
+	to        string
+	createdAt time.Time
+
+	// messagingProduct is echoed in the call's callbacks and CDR
+	messagingProduct string
+	scenario         string
+
+	// priority decides eviction order at --max-calls, see admitCall
+	priority int
+
+	// callbackData is the request's biz_opaque_callback_data, echoed in
+	// every response, callback and CDR for the call
+	callbackData string
+
+	// callbackURL receives the call's lifecycle events, if set
+	callbackURL string
+
+	// iceCredentials are only recorded with --expose-ice-credentials
+	iceCredentials *ICECredentials
+
+	// media is the MediaOpus/MediaVideo/MediaPCMU type of an offered call
+	media string
+
+	// simulcast are the lower layers of a simulcast offer, written alongside
+	// the audio track; see writeSimulcastLayers
+	simulcast []simulcastLayer
+
+	// loopMedia restarts the call's audio from the top when it runs out
+	loopMedia bool
+
+	// ctx is cancelled when the call is torn down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// goroutines counts this call's running goroutines; see goTracked
+	goroutines atomic.Int32
+
+	// stopped tracks the goroutines that exit on ctx, which teardown waits
+	// for before closing the PeerConnection; see goTracked
+	stopped sync.WaitGroup
+
+	// muted pauses outgoing audio without tearing the call down
+	muted atomic.Bool
+
+	// writeErrors counts failed WriteSample calls, including tolerated ones
+	writeErrors atomic.Int64
+
+	// integrity counts the samples checked by --media-checksum; see checkSample
+	integrity struct {
+		checked, bytes, mismatches atomic.Int64
+	}
+
+	// connected is closed on the first ICE-connected event
+	connected     chan struct{}
+	connectedOnce sync.Once
+
+	mu       sync.Mutex
+	state    CallState
+	quality  RTCPQuality
+	timeline []TimelineEvent
+	running  map[string]int
+
+	// plannedDuration is how long the call stays up once accepted; see scheduleCallDuration
+	plannedDuration time.Duration
+
+	// pendingCandidates are trickled remote candidates that arrived before
+	// the remote description; see addRemoteCandidate
+	pendingCandidates []webrtc.ICECandidateInit
+
+	// autoRemove is the reaper's timer, nil when auto-removal is off; a
+	// heartbeat resets it to autoRemoveTimeout from now. See extendAutoRemove.
+	autoRemove        *time.Timer
+	autoRemoveTimeout time.Duration
+	expiresAt         time.Time
+
+	// samples is the bounded --stats-sample-interval series; see addSample
+	samples []StatsSample
+
+	// connectionState is the PeerConnection's aggregate (ICE plus DTLS)
+	// state; see watchConnectionState
+	connectionState webrtc.PeerConnectionState
 }
 
 type Offer struct {
@@ -24,10 +118,39 @@ type Offer struct {
 }
 
 type OfferRequest struct {
-	To          string `json:"to"`
-	CallbackURL string `json:"callback_url,omitempty"`
-	CallID      string `json:"call_id,omitempty"`
-	From        string `json:"from"`
+	To           string            `json:"to"`
+	CallbackURL  string            `json:"callback_url,omitempty"`
+	CallID       string            `json:"call_id,omitempty"`
+	From         string            `json:"from"`
+	ResponseMode string            `json:"response_mode,omitempty"`
+	Media        string            `json:"media,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+
+	MessagingProduct string `json:"messaging_product,omitempty"`
+
+	// WaitForConnect holds the response until ICE connects; the client must
+	// then get the offer from the callback to be able to accept it
+	WaitForConnect bool `json:"wait_for_connect,omitempty"`
+
+	// DTMFSequence is rejected until DTMF sending is supported
+	DTMFSequence string `json:"dtmf_sequence,omitempty"`
+
+	// TimeoutSeconds overrides --call-timeout; -1 keeps the call until terminated
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// TTLSeconds overrides --call-timeout, from 1s up to an hour
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+
+	// Simulcast offers the audio as h/m/l RID layers instead of one encoding
+	Simulcast bool `json:"simulcast,omitempty"`
+
+	// AudioFile streams this file from --audio-dir instead of --audio-file
+	AudioFile string `json:"audio_file,omitempty"`
+	// audioSource is AudioFile resolved inside --audio-dir
+	audioSource string
+
+	// Loop replays the audio until the call ends instead of going silent
+	Loop bool `json:"loop,omitempty"`
 }
 
 type OfferResponse struct {
@@ -36,24 +159,41 @@ type OfferResponse struct {
 	CallbackResponse string `json:"callback_response,omitempty"`
 }
 
+type MinimalOfferResponse struct {
+	CallID string `json:"call_id"`
+	SDP    string `json:"sdp"`
+	Type   string `json:"type"`
+	Status string `json:"status,omitempty"`
+}
+
 type ActionRequest struct {
 	CallID           string         `json:"call_id"`
 	Action           string         `json:"action"`
 	Connection       map[string]any `json:"connection,omitempty"`
 	Session          map[string]any `json:"session,omitempty"`
 	MessagingProduct string         `json:"messaging_product"`
+
+	// Candidate is the trickled ICE candidate of a "candidate" action
+	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
 }
 
 type Call struct {
-	ID         string         `json:"id"`
-	From       string         `json:"from"`
-	To         string         `json:"to"`
-	Event      string         `json:"event"`
-	Timestamp  string         `json:"timestamp"`
-	Direction  string         `json:"direction"`
-	Status     string         `json:"status,omitempty"`
-	Connection map[string]any `json:"connection,omitempty"`
-	Session    map[string]any `json:"session,omitempty"`
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	Direction string `json:"direction"`
+	Status    string `json:"status,omitempty"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	Duration  int64  `json:"duration,omitempty"`
+
+	MessagingProduct string         `json:"messaging_product,omitempty"`
+	Connection       map[string]any `json:"connection,omitempty"`
+	Session          map[string]any `json:"session,omitempty"`
+	CallbackData     string         `json:"biz_opaque_callback_data,omitempty"`
+	NodeID           string         `json:"node_id,omitempty"`
 }
 
 type Metadata struct {
@@ -89,8 +229,11 @@ type SessionDescription struct {
 }
 
 type AnswerResponse struct {
-	CallID string             `json:"call_id"`
-	Answer SessionDescription `json:"answer"`
+	CallID       string             `json:"call_id"`
+	To           string             `json:"to,omitempty"`
+	Answer       SessionDescription `json:"answer"`
+	CallbackData string             `json:"biz_opaque_callback_data,omitempty"`
+	Candidates   []SDPCandidate     `json:"candidates,omitempty"`
 }
 
 type AnswerRequest struct {
@@ -101,4 +244,22 @@ type AnswerRequest struct {
 	MessagingProduct string             `json:"messaging_product"`
 	CallbackURL      string             `json:"callback_url,omitempty"`
 	CallbackData     string             `json:"biz_opaque_callback_data,omitempty"`
+	TimeoutSeconds   int                `json:"timeout_seconds,omitempty"`
+	Metadata         map[string]string  `json:"metadata,omitempty"`
+	TTLSeconds       int                `json:"ttl_seconds,omitempty"`
+
+	// GatherDelayMs overrides --answer-gather-delay for this answer
+	GatherDelayMs *int `json:"gather_delay_ms,omitempty"`
+
+	// AudioFile streams this file from --audio-dir instead of
+	// --audio-file or --answer-audio-pool
+	AudioFile string `json:"audio_file,omitempty"`
+	// audioSource is AudioFile resolved inside --audio-dir
+	audioSource string
+
+	// Loop replays the audio until the call ends instead of going silent
+	Loop bool `json:"loop,omitempty"`
+
+	// IncludeCandidates is ?include_candidates=true on /load/calls
+	IncludeCandidates bool `json:"-"`
 }
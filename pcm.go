@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// Raw audio sources are 16-bit mono PCM at 48kHz: a .wav file, or the
+// same samples little-endian with no header in a .pcm file
+const (
+	pcmSampleRate  = 48000
+	pcmFrameLength = pcmSampleRate / 50 // 20ms
+)
+
+// opusEncoder encodes one 20ms frame of 48kHz mono PCM as an Opus packet
+type opusEncoder interface {
+	Encode(pcm []int16) ([]byte, error)
+}
+
+// newOpusEncoder is set by a build that links an Opus encoder. The only Go
+// encoders wrap libopus through cgo, so none is linked by default; Opus
+// calls then stream fallbackAudioFile in place of a raw source.
+var newOpusEncoder func() (opusEncoder, error)
+
+var errNoOpusEncoder = errors.New("no Opus encoder in this build")
+
+// fallbackAudioFile is the Ogg/Opus clip Opus calls play when their source
+// is raw PCM that could not be encoded; it is also the --audio-file default
+const fallbackAudioFile = "output20ms.ogg"
+
+// opusUnavailable reports whether audio is raw PCM with no Opus encoding,
+// so it can only be streamed as is on a pcmu call
+func opusUnavailable(audio *PrecompiledMedia) bool {
+	return len(audio.Samples) == 0 && audio.pcmu != nil
+}
+
+// opusMedia returns the Opus samples to stream for audio, falling back to
+// fallbackAudioFile when audio could not be encoded
+func opusMedia(audio *PrecompiledMedia) (*PrecompiledMedia, error) {
+	if !opusUnavailable(audio) {
+		return audio, nil
+	}
+	fallback, err := loadPrecompiledMedia(fallbackAudioFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w, and loading the Ogg fallback failed: %w", audio.Filename, errNoOpusEncoder, err)
+	}
+	debugf("%s: %v, streaming %s instead", audio.Filename, errNoOpusEncoder, fallback.Filename)
+	return fallback, nil
+}
+
+func isPCMSource(source string) bool {
+	ext := strings.ToLower(filepath.Ext(source))
+	return ext == ".wav" || ext == ".pcm"
+}
+
+// precompilePCMFile decodes a .wav or .pcm file and, when an Opus encoder
+// is linked, packetizes it into 20ms Opus samples
+func precompilePCMFile(filename string) (*PrecompiledMedia, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening PCM file: %w", err)
+	}
+	defer file.Close()
+
+	var pcm []int16
+	if strings.EqualFold(filepath.Ext(filename), ".wav") {
+		pcm, err = decodeWAV(filename, file)
+	} else {
+		pcm, err = readPCM(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pcm) == 0 {
+		return nil, fmt.Errorf("%s: no audio samples", filename)
+	}
+
+	compiled := &PrecompiledMedia{
+		Filename:   filename,
+		Channels:   1,
+		SampleRate: pcmSampleRate,
+		Duration:   time.Duration(len(pcm)) * time.Second / pcmSampleRate,
+	}
+	compiled.pcmu = transcodePCMU(compiled, pcm)
+	if newOpusEncoder != nil {
+		if err := encodeOpus(compiled, pcm); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	log.Printf("🎵 Loaded %s: 16-bit PCM, 1 channel(s), %d Hz, %s\n", filename, pcmSampleRate, compiled.Duration.Round(time.Millisecond))
+	return compiled, nil
+}
+
+// decodeWAV reads the samples of a RIFF/WAVE file holding 16-bit mono
+// PCM at 48kHz, skipping any chunks other than fmt and data
+func decodeWAV(name string, r io.Reader) ([]int16, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("%s: reading WAV header: %w", name, err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%s: not a RIFF/WAVE file", name)
+	}
+
+	formatSeen := false
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("%s: no data chunk: %w", name, err)
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("%s: fmt chunk is %d bytes", name, size)
+			}
+			format := make([]byte, size)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, fmt.Errorf("%s: reading fmt chunk: %w", name, err)
+			}
+			audioFormat := binary.LittleEndian.Uint16(format[0:2])
+			channels := binary.LittleEndian.Uint16(format[2:4])
+			sampleRate := binary.LittleEndian.Uint32(format[4:8])
+			bitsPerSample := binary.LittleEndian.Uint16(format[14:16])
+			if audioFormat != 1 || channels != 1 || sampleRate != pcmSampleRate || bitsPerSample != 16 {
+				return nil, fmt.Errorf("%s: want 16-bit mono PCM at %d Hz, got format %d, %d channel(s), %d Hz, %d bits",
+					name, pcmSampleRate, audioFormat, channels, sampleRate, bitsPerSample)
+			}
+			formatSeen = true
+		case "data":
+			if !formatSeen {
+				return nil, fmt.Errorf("%s: data chunk before fmt chunk", name)
+			}
+			return readPCM(io.LimitReader(r, size))
+		default:
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return nil, fmt.Errorf("%s: skipping %q chunk: %w", name, id, err)
+			}
+		}
+		// Chunks are padded to an even length
+		if size%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+}
+
+// readPCM reads little-endian 16-bit samples to the end of r
+func readPCM(r io.Reader) ([]int16, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading PCM samples: %w", err)
+	}
+	pcm := make([]int16, len(data)/2)
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return pcm, nil
+}
+
+// pcmFrames splits pcm into 20ms frames, padding the last with silence
+func pcmFrames(pcm []int16) [][]int16 {
+	frames := make([][]int16, 0, (len(pcm)+pcmFrameLength-1)/pcmFrameLength)
+	for start := 0; start < len(pcm); start += pcmFrameLength {
+		frame := make([]int16, pcmFrameLength)
+		copy(frame, pcm[start:])
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// encodeOpus fills in audio's samples by encoding pcm with the linked
+// Opus encoder
+func encodeOpus(audio *PrecompiledMedia, pcm []int16) error {
+	encoder, err := newOpusEncoder()
+	if err != nil {
+		return fmt.Errorf("creating Opus encoder: %w", err)
+	}
+	for _, frame := range pcmFrames(pcm) {
+		packet, err := encoder.Encode(frame)
+		if err != nil {
+			return fmt.Errorf("encoding Opus: %w", err)
+		}
+		audio.Samples = append(audio.Samples, media.Sample{Data: packet, Duration: 20 * time.Millisecond})
+		audio.Checksums = append(audio.Checksums, crc32.ChecksumIEEE(packet))
+	}
+	return nil
+}
+
+// transcodePCMU turns audio's 48kHz pcm into 20ms G.711 µ-law samples at
+// 8kHz, averaging each 6 input samples into one
+func transcodePCMU(audio *PrecompiledMedia, pcm []int16) *PrecompiledMedia {
+	const decimation = pcmSampleRate / 8000
+	transcoded := &PrecompiledMedia{Filename: audio.Filename, Channels: 1, SampleRate: 8000, Duration: audio.Duration}
+	for _, frame := range pcmFrames(pcm) {
+		packet := make([]byte, len(frame)/decimation)
+		for i := range packet {
+			sum := 0
+			for _, sample := range frame[i*decimation : (i+1)*decimation] {
+				sum += int(sample)
+			}
+			packet[i] = linearToULaw(int16(sum / decimation))
+		}
+		transcoded.Samples = append(transcoded.Samples, media.Sample{Data: packet, Duration: 20 * time.Millisecond})
+		transcoded.Checksums = append(transcoded.Checksums, crc32.ChecksumIEEE(packet))
+	}
+	return transcoded
+}
+
+// linearToULaw is the G.711 µ-law encoding of a 16-bit sample
+func linearToULaw(sample int16) byte {
+	const (
+		bias = 0x84
+		clip = 32635
+	)
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
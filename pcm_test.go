@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// wavFile builds a RIFF/WAVE file around pcm, with a LIST chunk of odd
+// length ahead of the data as real encoders write
+func wavFile(channels uint16, sampleRate uint32, pcm []int16) []byte {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, pcm)
+
+	var wav bytes.Buffer
+	chunk := func(id string, body []byte) {
+		wav.WriteString(id)
+		binary.Write(&wav, binary.LittleEndian, uint32(len(body)))
+		wav.Write(body)
+		if len(body)%2 == 1 {
+			wav.WriteByte(0)
+		}
+	}
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 1)
+	binary.LittleEndian.PutUint16(format[2:], channels)
+	binary.LittleEndian.PutUint32(format[4:], sampleRate)
+	binary.LittleEndian.PutUint32(format[8:], sampleRate*uint32(channels)*2)
+	binary.LittleEndian.PutUint16(format[12:], channels*2)
+	binary.LittleEndian.PutUint16(format[14:], 16)
+
+	wav.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	chunk("fmt ", format)
+	chunk("LIST", []byte("INFOtest!"))
+	chunk("data", data.Bytes())
+	return wav.Bytes()
+}
+
+// tone is a 440Hz sine at 48kHz lasting n samples
+func tone(n int) []int16 {
+	pcm := make([]int16, n)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/pcmSampleRate))
+	}
+	return pcm
+}
+
+func TestLinearToULaw(t *testing.T) {
+	tests := []struct {
+		sample int16
+		want   byte
+	}{
+		{0, 0xFF},
+		{1000, 0xCE},
+		{-1000, 0x4E},
+		{32767, 0x80},
+		{-32768, 0x00},
+	}
+	for _, tt := range tests {
+		if got := linearToULaw(tt.sample); got != tt.want {
+			t.Errorf("linearToULaw(%d) = %#x, want %#x", tt.sample, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeWAV(t *testing.T) {
+	pcm := tone(1000)
+	got, err := decodeWAV("tone.wav", bytes.NewReader(wavFile(1, pcmSampleRate, pcm)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(pcm) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(pcm))
+	}
+	for i := range pcm {
+		if got[i] != pcm[i] {
+			t.Fatalf("sample %d = %d, want %d", i, got[i], pcm[i])
+		}
+	}
+
+	for name, wav := range map[string][]byte{
+		"stereo":    wavFile(2, pcmSampleRate, pcm),
+		"8kHz":      wavFile(1, 8000, pcm),
+		"not riff":  []byte("OggS not a wav file at all"),
+		"truncated": wavFile(1, pcmSampleRate, pcm)[:20],
+	} {
+		if _, err := decodeWAV(name, bytes.NewReader(wav)); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+}
+
+// fakeOpusEncoder stands in for libopus: one packet per frame
+type fakeOpusEncoder struct{}
+
+func (fakeOpusEncoder) Encode(pcm []int16) ([]byte, error) {
+	if len(pcm) != pcmFrameLength {
+		return nil, errors.New("not a 20ms frame")
+	}
+	return []byte{0xF8, byte(pcm[0]), byte(pcm[0] >> 8)}, nil
+}
+
+func TestPCMSources(t *testing.T) {
+	dir := t.TempDir()
+	app := newTestApp(t, func(cfg *Config) { cfg.AudioDir = dir })
+
+	// 50 frames and a bit, so the last frame is padded
+	pcm := tone(50*pcmFrameLength + 100)
+	if err := os.WriteFile(filepath.Join(dir, "tone.wav"), wavFile(1, pcmSampleRate, pcm), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, pcm)
+	if err := os.WriteFile(filepath.Join(dir, "tone.pcm"), raw.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"tone.wav", "tone.pcm"} {
+		t.Run(name, func(t *testing.T) {
+			audio, err := loadPrecompiledMedia(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opus, err := opusMedia(audio); err != nil || opus.Filename != fallbackAudioFile {
+				t.Errorf("opusMedia = %v, %v, want the %s fallback", opus, err, fallbackAudioFile)
+			}
+			pcmu := pcmuMedia(audio)
+			if len(pcmu.Samples) != 51 {
+				t.Fatalf("%d PCMU samples, want 51", len(pcmu.Samples))
+			}
+			for i, sample := range pcmu.Samples {
+				if len(sample.Data) != 160 {
+					t.Fatalf("PCMU sample %d is %d bytes, want 160", i, len(sample.Data))
+				}
+			}
+			if bytes.Equal(pcmu.Samples[10].Data, pcmuSilence) {
+				t.Error("PCMU sample carries silence, not the tone")
+			}
+
+			resp, callID := offer(t, app, map[string]any{"audio_file": name, "media": MediaPCMU})
+			if resp.StatusCode != fiber.StatusOK || callID == "" {
+				t.Errorf("pcmu offer = %d", resp.StatusCode)
+			}
+			// Asked for by name, a raw file that can't play on the call is the client's mistake
+			for _, kind := range []string{MediaOpus, ""} {
+				resp, reply := doJSON(t, app, fiber.MethodPost, "/load/offer", map[string]any{"to": "15550001", "audio_file": name, "media": kind})
+				if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(reply["error"].(string), "pcmu") {
+					t.Errorf("offer with media %q = %d %v, want 400 pointing at pcmu", kind, resp.StatusCode, reply)
+				}
+			}
+			_, err = handleAnswer(AnswerRequest{CallID: "inbound-" + name, Action: "connect", AudioFile: name, Session: SessionDescription{Type: "offer", SDP: remoteOffer(t)}})
+			if got := statusOf(err); got != fiber.StatusBadRequest {
+				t.Errorf("answer = %d (%v), want 400", got, err)
+			}
+		})
+	}
+
+	t.Run("with an Opus encoder", func(t *testing.T) {
+		newOpusEncoder = func() (opusEncoder, error) { return fakeOpusEncoder{}, nil }
+		t.Cleanup(func() { newOpusEncoder = nil })
+		source := filepath.Join(dir, "encoded.wav")
+		if err := os.WriteFile(source, wavFile(1, pcmSampleRate, pcm), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		audio, err := loadPrecompiledMedia(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opus, err := opusMedia(audio); err != nil || opus != audio {
+			t.Errorf("opusMedia = %v, %v, want the encoded source", opus, err)
+		}
+		if len(audio.Samples) != 51 || len(audio.Checksums) != 51 {
+			t.Fatalf("%d Opus samples and %d checksums, want 51", len(audio.Samples), len(audio.Checksums))
+		}
+
+		resp, _ := offer(t, app, map[string]any{"audio_file": "encoded.wav", "media": MediaOpus})
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("opus offer = %d, want 200", resp.StatusCode)
+		}
+	})
+
+	t.Run("as --audio-file", func(t *testing.T) {
+		source := filepath.Join(dir, "tone.wav")
+		app := newTestApp(t, func(cfg *Config) { cfg.AudioFile = source })
+		for _, kind := range []string{MediaOpus, MediaPCMU} {
+			if resp, _ := offer(t, app, map[string]any{"media": kind}); resp.StatusCode != fiber.StatusOK {
+				t.Errorf("%s offer = %d, want 200", kind, resp.StatusCode)
+			}
+		}
+		answer := AnswerRequest{CallID: "inbound-wav", Action: "connect", Session: SessionDescription{Type: "offer", SDP: remoteOffer(t)}}
+		if _, err := handleAnswer(answer); err != nil {
+			t.Errorf("answer = %v, want it answered with the Ogg fallback", err)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// webrtcAPI is shared by every PeerConnection. setupWebRTC rebuilds it from
+// the command-line settings before the server starts.
+var webrtcAPI = webrtc.NewAPI()
+
+// simulcastAPI is webrtcAPI plus the MID and RID header extensions on
+// audio, so receivers can demultiplex an offer's simulcast layers. Kept
+// separate so single-encoding offers are unchanged.
+var simulcastAPI = webrtc.NewAPI()
+
+// dtlsCertificates is the --dtls-cert-pool-size pool, handed out round-robin
+var (
+	dtlsCertificates []webrtc.Certificate
+	nextCertificate  atomic.Uint64
+)
+
+// pooledCertificates picks a certificate from the pool for a new
+// PeerConnection, or nil to let pion generate one
+func pooledCertificates() []webrtc.Certificate {
+	if len(dtlsCertificates) == 0 {
+		return nil
+	}
+	n := nextCertificate.Add(1) - 1
+	return []webrtc.Certificate{dtlsCertificates[n%uint64(len(dtlsCertificates))]}
+}
+
+// generateCertificates creates size certificates with the same ECDSA P-256
+// keys pion would generate per PeerConnection
+func generateCertificates(size int) ([]webrtc.Certificate, error) {
+	certificates := make([]webrtc.Certificate, 0, size)
+	for range size {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := webrtc.GenerateCertificate(key)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, *certificate)
+	}
+	return certificates, nil
+}
+
+// ICE transport preferences for --ice-transport-pref
+const (
+	ICETransportUDP     = "udp"
+	ICETransportTCP     = "tcp"
+	ICETransportTCPOnly = "tcp-only"
+)
+
+func validateICETransportPref(pref string, iceTCP bool) error {
+	switch pref {
+	case ICETransportUDP:
+		return nil
+	case ICETransportTCP, ICETransportTCPOnly:
+		if !iceTCP {
+			return fmt.Errorf("ice-transport-pref %s needs --ice-tcp", pref)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid ice-transport-pref %q (want %s, %s or %s)", pref, ICETransportUDP, ICETransportTCP, ICETransportTCPOnly)
+}
+
+// setupICETCP listens for ICE-TCP and limits gathering to the network
+// types --ice-transport-pref allows
+func setupICETCP(settingEngine *webrtc.SettingEngine) error {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.ICETCPPort})
+	if err != nil {
+		return fmt.Errorf("listening for ICE-TCP: %w", err)
+	}
+	settingEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, listener, 8))
+
+	networkTypes := []webrtc.NetworkType{webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6}
+	if config.ICETransportPref != ICETransportTCPOnly {
+		networkTypes = append(networkTypes, webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6)
+	}
+	settingEngine.SetNetworkTypes(networkTypes)
+	log.Printf("✅ Accepting ICE-TCP on %s, transport preference %s\n", listener.Addr(), config.ICETransportPref)
+	return nil
+}
+
+func setupWebRTC() error {
+	settingEngine := webrtc.SettingEngine{}
+
+	if config.DTLSCertPoolSize > 0 {
+		certificates, err := generateCertificates(config.DTLSCertPoolSize)
+		if err != nil {
+			return fmt.Errorf("generating DTLS certificates: %w", err)
+		}
+		dtlsCertificates = certificates
+		log.Printf("✅ Generated %d DTLS certificates, valid until %s\n", len(certificates), certificates[0].Expires().Format(time.RFC3339))
+	}
+
+	if config.HostOnly {
+		// Same-network load tests: no mDNS and no server-reflexive/relay
+		// gathering, so offers are ready as soon as host candidates are
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+		settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6})
+	}
+	if config.ICETCP {
+		if err := setupICETCP(&settingEngine); err != nil {
+			return err
+		}
+	}
+
+	if config.DSCP >= 0 {
+		dscpNet, err := newDSCPNet(config.DSCP)
+		if err != nil {
+			return err
+		}
+		if err := checkDSCPSupport(dscpNet); err != nil {
+			return err
+		}
+		settingEngine.SetNet(dscpNet)
+		log.Printf("✅ Marking media packets with DSCP %d\n", config.DSCP)
+	}
+
+	webrtcAPI = webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+
+	mediaEngine, registry, err := simulcastMediaEngine()
+	if err != nil {
+		return err
+	}
+	simulcastAPI = webrtc.NewAPI(
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	)
+	return nil
+}
+
+func simulcastMediaEngine() (*webrtc.MediaEngine, *interceptor.Registry, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, err
+	}
+	for _, uri := range []string{sdp.SDESMidURI, sdp.SDESRTPStreamIDURI} {
+		if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, nil, err
+		}
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, nil, err
+	}
+	registry.Add(simulcastHeaderFactory{})
+	return mediaEngine, registry, nil
+}
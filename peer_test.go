@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func benchmarkPeerConnections(b *testing.B, certificates func() []webrtc.Certificate) {
+	b.ReportAllocs()
+	for b.Loop() {
+		pc, err := webrtc.NewAPI().NewPeerConnection(webrtc.Configuration{Certificates: certificates()})
+		if err != nil {
+			b.Fatal(err)
+		}
+		pc.Close()
+	}
+}
+
+// A PeerConnection reusing a certificate from --dtls-cert-pool-size
+func BenchmarkPeerConnectionPooledCertificate(b *testing.B) {
+	certificates, err := generateCertificates(4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	dtlsCertificates = certificates
+	b.Cleanup(func() { dtlsCertificates = nil })
+	benchmarkPeerConnections(b, pooledCertificates)
+}
+
+// A PeerConnection generating its own certificate, as pion does by default
+func BenchmarkPeerConnectionGeneratedCertificate(b *testing.B) {
+	benchmarkPeerConnections(b, func() []webrtc.Certificate { return nil })
+}
+
+func TestPooledCertificatesRoundRobin(t *testing.T) {
+	certificates, err := generateCertificates(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtlsCertificates = certificates
+	t.Cleanup(func() { dtlsCertificates = nil })
+
+	first, second, third := pooledCertificates(), pooledCertificates(), pooledCertificates()
+	if first[0].Equals(second[0]) {
+		t.Error("consecutive PeerConnections got the same certificate from a pool of 2")
+	}
+	if !first[0].Equals(third[0]) {
+		t.Error("the pool did not wrap around")
+	}
+}
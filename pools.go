@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// NumberPool hands out phone numbers round-robin across calls
+type NumberPool struct {
+	numbers []string
+	next    atomic.Uint64
+}
+
+func newNumberPool(numbers []string) *NumberPool {
+	return &NumberPool{numbers: numbers}
+}
+
+// Next returns the next number in the pool, or "" if the pool is empty
+func (p *NumberPool) Next() string {
+	if len(p.numbers) == 0 {
+		return ""
+	}
+	i := p.next.Add(1) - 1
+	return p.numbers[i%uint64(len(p.numbers))]
+}
+
+// toPool supplies business numbers for inbound calls that omit `to`
+var toPool = newNumberPool(nil)
+
+// fromPool supplies caller numbers for offers that omit `from` under
+// --missing-number-policy=pool
+var fromPool = newNumberPool(nil)
+
+// What an offer missing from or to does, set with --missing-number-policy
+const (
+	MissingNumberPassthrough = "passthrough" // signal the blanks as they are
+	MissingNumberReject      = "reject"      // refuse the offer with 400
+	MissingNumberPool        = "pool"        // draw substitutes from --from-pool and --to-pool
+)
+
+func validateMissingNumberPolicy(policy string) error {
+	switch policy {
+	case MissingNumberPassthrough, MissingNumberReject, MissingNumberPool:
+		return nil
+	}
+	return fmt.Errorf("invalid missing number policy %q (want %s, %s or %s)", policy, MissingNumberPassthrough, MissingNumberReject, MissingNumberPool)
+}
+
+// fillMissingNumbers applies --missing-number-policy to an offer whose
+// from or to is empty, so callbacks never carry a blank number by accident
+func fillMissingNumbers(request *OfferRequest, policy string) error {
+	if request.From != "" && request.To != "" {
+		return nil
+	}
+	switch policy {
+	case MissingNumberReject:
+		return fmt.Errorf("from and to are required")
+	case MissingNumberPool:
+		if request.From == "" {
+			if request.From = fromPool.Next(); request.From == "" {
+				return fmt.Errorf("from is missing and --from-pool is empty")
+			}
+		}
+		if request.To == "" {
+			if request.To = toPool.Next(); request.To == "" {
+				return fmt.Errorf("to is missing and --to-pool is empty")
+			}
+		}
+	}
+	return nil
+}
+
+// answerAudioPool supplies audio sources for inbound calls when
+// --answer-audio-pool is set
+var answerAudioPool = newNumberPool(nil)
+
+// answerAudioSource picks the file streamed on the next inbound call
+func answerAudioSource(cfg Config) string {
+	if source := answerAudioPool.Next(); source != "" {
+		return source
+	}
+	return defaultAudioSource(cfg)
+}
+
+type weightedURL struct {
+	url    string
+	weight int
+}
+
+// weightedURLs is a flag.Value for URL lists with optional weights, e.g.
+// "3:http://a/cb,http://b/cb" (weight 3 and the default 1)
+type weightedURLs []weightedURL
+
+func (w *weightedURLs) String() string {
+	parts := make([]string, len(*w))
+	for i, entry := range *w {
+		parts[i] = fmt.Sprintf("%d:%s", entry.weight, entry.url)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (w *weightedURLs) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		entry := weightedURL{url: item, weight: 1}
+		// A URL's scheme is never an integer, so a numeric prefix is a weight
+		if weightStr, rest, ok := strings.Cut(item, ":"); ok {
+			if weight, err := strconv.Atoi(weightStr); err == nil {
+				if weight < 1 {
+					return fmt.Errorf("callback URL weight for %s must be positive", rest)
+				}
+				entry = weightedURL{url: rest, weight: weight}
+			}
+		}
+		if u, err := url.Parse(entry.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("callback URL %q must be an http(s) URL", entry.url)
+		}
+		*w = append(*w, entry)
+	}
+	return nil
+}
+
+// pick returns a URL chosen by weight, or "" for an empty list
+func (w weightedURLs) pick() string {
+	total := 0
+	for _, entry := range w {
+		total += entry.weight
+	}
+	if total == 0 {
+		return ""
+	}
+	n := rand.N(total)
+	for _, entry := range w {
+		if n < entry.weight {
+			return entry.url
+		}
+		n -= entry.weight
+	}
+	return ""
+}
+
+func (w weightedURLs) contains(callbackURL string) bool {
+	for _, entry := range w {
+		if entry.url == callbackURL {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ProbeScenario labels the probe's own calls in metrics
+const ProbeScenario = "probe"
+
+// ProbeResult is the outcome of one loopback probe call
+type ProbeResult struct {
+	At        time.Time `json:"at"`
+	OK        bool      `json:"ok"`
+	SetupMs   int64     `json:"setup_ms,omitempty"`
+	SLABreach bool      `json:"sla_breach"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ProbeSnapshot is the probe section of /stats
+type ProbeSnapshot struct {
+	Runs        int64        `json:"runs"`
+	Successes   int64        `json:"successes"`
+	SLABreaches int64        `json:"sla_breaches"`
+	SuccessRate float64      `json:"success_rate"`
+	SLAMs       int64        `json:"sla_ms"`
+	Last        *ProbeResult `json:"last,omitempty"`
+}
+
+type probeRecorder struct {
+	mu          sync.Mutex
+	runs        int64
+	successes   int64
+	slaBreaches int64
+	last        *ProbeResult
+}
+
+var probe probeRecorder
+
+func (p *probeRecorder) record(result ProbeResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runs++
+	if result.OK {
+		p.successes++
+	}
+	if result.SLABreach {
+		p.slaBreaches++
+	}
+	p.last = &result
+}
+
+// snapshot returns nil until --probe-interval is set
+func (p *probeRecorder) snapshot() *ProbeSnapshot {
+	if config.ProbeInterval <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := &ProbeSnapshot{
+		Runs:        p.runs,
+		Successes:   p.successes,
+		SLABreaches: p.slaBreaches,
+		SLAMs:       config.ProbeSLA.Milliseconds(),
+		Last:        p.last,
+	}
+	if p.runs > 0 {
+		snap.SuccessRate = float64(p.successes) / float64(p.runs)
+	}
+	return snap
+}
+
+// startProbe runs a loopback call every interval until the returned stop
+// function is called, timing offer to ICE-connected against sla
+func startProbe(interval, sla time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				result := runProbe(max(interval, sla))
+				if result.OK && sla > 0 && time.Duration(result.SetupMs)*time.Millisecond > sla {
+					result.SLABreach = true
+				}
+				probe.record(result)
+
+				switch {
+				case !result.OK:
+					log.Printf("❌ Probe failed: %s\n", result.Error)
+				case result.SLABreach:
+					log.Printf("❌ Probe SLA breach: setup took %dms (sla %s)\n", result.SetupMs, sla)
+				default:
+					debugf("Probe ok: setup took %dms", result.SetupMs)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// runProbe places an offer through the regular offer path, answers it from
+// an in-process callee and waits for ICE to connect, then tears it down.
+// Calls are labelled with ProbeScenario so they can be told apart from load.
+func runProbe(timeout time.Duration) ProbeResult {
+	started := time.Now()
+	result := ProbeResult{At: started}
+
+	resp, err := handleOffer(OfferRequest{
+		From:         "probe-caller",
+		To:           "probe",
+		ResponseMode: ResponseModeMinimal,
+		Metadata:     map[string]string{MetadataScenario: ProbeScenario},
+	})
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			result.Error = fmt.Sprintf("offer rejected with %d: %v", reqErr.status, reqErr.body["error"])
+		} else {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	offer := resp.(MinimalOfferResponse)
+	defer teardownCall(offer.CallID, ReasonProbeDone)
+
+	val, ok := ActionChannels.Load(offer.CallID)
+	if !ok {
+		result.Error = "probe call ended before it could be accepted"
+		return result
+	}
+	details := val.(*CallIDDetails)
+	if err := autoAccept(offer.CallID, details, offer.SDP); err != nil {
+		result.Error = fmt.Sprintf("accept: %v", err)
+		return result
+	}
+
+	timer := time.NewTimer(timeout - time.Since(started))
+	defer timer.Stop()
+	select {
+	case <-details.connected:
+		result.OK = true
+		result.SetupMs = time.Since(started).Milliseconds()
+	case <-details.ctx.Done():
+		result.Error = "probe call ended before ICE connected"
+	case <-timer.C:
+		result.Error = fmt.Sprintf("ICE did not connect within %s", timeout)
+	}
+	return result
+}
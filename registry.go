@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// What happens to a new call when --max-calls are already registered,
+// set with --registry-full-policy
+const (
+	RegistryFullReject      = "reject"       // refuse the new call with 429
+	RegistryFullEvictOldest = "evict-oldest" // tear down the oldest call to make room
+)
+
+func validateRegistryFullPolicy(policy string) error {
+	switch policy {
+	case RegistryFullReject, RegistryFullEvictOldest:
+		return nil
+	}
+	return fmt.Errorf("invalid registry full policy %q (want %s or %s)", policy, RegistryFullReject, RegistryFullEvictOldest)
+}
+
+var errRegistryFull = errors.New("too many active calls")
+
+// MetadataPriority is the request metadata key holding a call's priority.
+// Lower priorities are evicted first; calls without one get DefaultPriority,
+// so evict-oldest stays first-in first-out unless priorities are used.
+const (
+	MetadataPriority = "priority"
+	DefaultPriority  = 0
+)
+
+func callPriority(metadata map[string]string) (int, error) {
+	value, ok := metadata[MetadataPriority]
+	if !ok {
+		return DefaultPriority, nil
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s metadata %q: want an integer", MetadataPriority, value)
+	}
+	return priority, nil
+}
+
+// admitMu serializes admission so concurrent requests do not all evict
+// (or all see room for) the same last slot
+var admitMu sync.Mutex
+
+// admitting counts calls admitted under --max-calls that are still being
+// set up, so a burst cannot overshoot the cap before any of it registers
+var admitting atomic.Int64
+
+// registryFullRetryAfter is the Retry-After sent with errRegistryFull
+const registryFullRetryAfter = time.Second
+
+// admitCall makes room for a new call of the given priority under
+// --max-calls, evicting the oldest of the lowest-priority calls or returning
+// errRegistryFull per --registry-full-policy. A call never evicts one of
+// higher priority. The admitted call holds a slot until release is called,
+// which the caller must do once the call is registered or has failed.
+func admitCall(priority int) (release func(), err error) {
+	cfg := currentConfig()
+	if cfg.MaxCalls <= 0 {
+		return func() {}, nil
+	}
+
+	admitMu.Lock()
+	defer admitMu.Unlock()
+	for registeredCalls()+int(admitting.Load()) >= cfg.MaxCalls {
+		if cfg.RegistryFullPolicy != RegistryFullEvictOldest {
+			return nil, errRegistryFull
+		}
+		// Calls still being set up can't be evicted
+		callID, victimPriority, ok := evictionCandidate()
+		if !ok || victimPriority > priority {
+			return nil, errRegistryFull
+		}
+		log.Printf("🔄 %s Evicting call with priority %d, %d calls registered\n", callID, victimPriority, cfg.MaxCalls)
+		if teardownCall(callID, ReasonEvicted) {
+			metrics.Evictions.Add(1)
+		}
+	}
+	admitting.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { admitting.Add(-1) }) }, nil
+}
+
+// Capacity headers set on offer and answer responses with --capacity-headers
+const (
+	HeaderActiveCalls = "X-Active-Calls"
+	HeaderMaxCalls    = "X-Max-Calls"
+	HeaderLoadFactor  = "X-Load-Factor"
+)
+
+// setCapacityHeaders tells a load orchestrator how full this node is. The
+// load factor is registered calls over --max-calls and is left out when
+// the registry is unlimited.
+func setCapacityHeaders(c *fiber.Ctx) {
+	cfg := currentConfig()
+	if !cfg.CapacityHeaders {
+		return
+	}
+	active := registeredCalls()
+	c.Set(HeaderActiveCalls, strconv.Itoa(active))
+	c.Set(HeaderMaxCalls, strconv.Itoa(cfg.MaxCalls))
+	if cfg.MaxCalls > 0 {
+		c.Set(HeaderLoadFactor, strconv.FormatFloat(float64(active)/float64(cfg.MaxCalls), 'f', 3, 64))
+	}
+}
+
+func registeredCalls() int {
+	count := 0
+	ActionChannels.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// evictionCandidate is the oldest of the lowest-priority registered calls
+func evictionCandidate() (string, int, bool) {
+	var victimID string
+	var victimPriority int
+	var victimAt time.Time
+	ActionChannels.Range(func(key, value any) bool {
+		details := value.(*CallIDDetails)
+		if victimID == "" || details.priority < victimPriority ||
+			details.priority == victimPriority && details.createdAt.Before(victimAt) {
+			victimID, victimPriority, victimAt = key.(string), details.priority, details.createdAt
+		}
+		return true
+	})
+	return victimID, victimPriority, victimID != ""
+}
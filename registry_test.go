@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Offers racing for the last slots must not all pass the --max-calls
+// check while their PeerConnections are still being set up
+func TestMaxCallsUnderConcurrentOffers(t *testing.T) {
+	const maxCalls = 5
+	app := newTestApp(t, func(cfg *Config) { cfg.MaxCalls = maxCalls })
+
+	var (
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		offers   sync.WaitGroup
+	)
+	for range maxCalls + 5 {
+		offers.Add(1)
+		go func() {
+			defer offers.Done()
+			resp, _ := offer(t, app, nil)
+			if resp.StatusCode == fiber.StatusTooManyRequests && resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+				t.Errorf("429 without Retry-After")
+			}
+			mu.Lock()
+			statuses[resp.StatusCode]++
+			mu.Unlock()
+		}()
+	}
+	offers.Wait()
+
+	if statuses[fiber.StatusOK] != maxCalls || statuses[fiber.StatusTooManyRequests] != 5 {
+		t.Errorf("statuses = %v, want %d × 200 and 5 × 429", statuses, maxCalls)
+	}
+	if n := registeredCalls(); n != maxCalls {
+		t.Errorf("%d calls registered, want %d", n, maxCalls)
+	}
+}
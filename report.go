@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// serverStarted is the start of the load run a report covers
+var serverStarted = time.Now()
+
+// RunReport is the machine-readable summary of a load run, served on
+// GET /report and written to --report-file at shutdown
+type RunReport struct {
+	StartedAt       time.Time `json:"started_at"`
+	GeneratedAt     time.Time `json:"generated_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+
+	PeakActiveCalls int64 `json:"peak_active_calls"`
+	BytesStreamed   int64 `json:"bytes_streamed"`
+	SamplesStreamed int64 `json:"samples_streamed"`
+
+	// Latency is keyed offer_setup.<scenario> and answer_wait.<outcome>
+	Latency map[string]LatencySummary `json:"latency"`
+	Errors  map[string]int64          `json:"errors"`
+
+	Stats StatsResponse `json:"stats"`
+}
+
+// LatencySummary condenses a histogram. Percentiles are interpolated
+// within buckets, so they are only as precise as the bucket bounds.
+type LatencySummary struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+}
+
+// quantile estimates the q-th quantile like Prometheus'
+// histogram_quantile, returning seconds, but never past the largest
+// observation.
+func (h *Histogram) quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	lower, below := 0.0, int64(0)
+	for i, bound := range h.bounds {
+		if float64(h.counts[i]) >= rank {
+			inBucket := h.counts[i] - below
+			estimate := bound
+			if inBucket > 0 {
+				estimate = lower + (bound-lower)*(rank-float64(below))/float64(inBucket)
+			}
+			return min(estimate, h.maxSecs)
+		}
+		lower, below = bound, h.counts[i]
+	}
+	return h.maxSecs
+}
+
+func (h *Histogram) summary() LatencySummary {
+	snap := h.snapshot()
+	summary := LatencySummary{
+		Count: snap.Count,
+		P50Ms: secondsToMs(h.quantile(0.5)),
+		P90Ms: secondsToMs(h.quantile(0.9)),
+		P99Ms: secondsToMs(h.quantile(0.99)),
+	}
+	if snap.Count > 0 {
+		summary.MeanMs = secondsToMs(snap.SumSeconds / float64(snap.Count))
+	}
+	return summary
+}
+
+func secondsToMs(secs float64) float64 {
+	return math.Round(secs*1e6) / 1000
+}
+
+// observeActive records a new high-water mark of registered calls
+func (m *Metrics) observeActive(active int64) {
+	for {
+		peak := m.PeakActiveCalls.Load()
+		if active <= peak || m.PeakActiveCalls.CompareAndSwap(peak, active) {
+			return
+		}
+	}
+}
+
+func (m *Metrics) report() RunReport {
+	now := time.Now()
+	stats := m.snapshot()
+
+	latency := map[string]LatencySummary{}
+	m.mu.Lock()
+	for name, sm := range m.scenarios {
+		latency["offer_setup."+name] = sm.OfferLatency.summary()
+	}
+	for outcome, h := range m.answerWait {
+		latency["answer_wait."+outcome] = h.summary()
+	}
+	m.mu.Unlock()
+
+	var callbackFailures int64
+	for _, counts := range stats.Callbacks {
+		callbackFailures += counts.Failed
+	}
+
+	return RunReport{
+		StartedAt:       serverStarted,
+		GeneratedAt:     now,
+		DurationSeconds: math.Round(now.Sub(serverStarted).Seconds()*1000) / 1000,
+		PeakActiveCalls: m.PeakActiveCalls.Load(),
+		BytesStreamed:   m.BytesStreamed.Load(),
+		SamplesStreamed: m.SamplesStreamed.Load(),
+		Latency:         latency,
+		Errors: map[string]int64{
+			"offer_failures":    stats.OfferFailures,
+			"answer_failures":   stats.AnswerFailures,
+			"write_errors":      m.WriteErrors.Load(),
+			"goroutine_leaks":   stats.GoroutineLeaks,
+			"shed_requests":     stats.ShedRequests,
+			"breaker_trips":     stats.BreakerTrips,
+			"callback_failures": callbackFailures,
+		},
+		Stats: stats,
+	}
+}
+
+func getReport(c *fiber.Ctx) error {
+	return c.JSON(metrics.report())
+}
+
+// writeReportFile writes the run report for --report-file
+func writeReportFile(path string) {
+	data, err := json.MarshalIndent(metrics.report(), "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		log.Printf("❌ Error writing report to %s: %v\n", path, err)
+		return
+	}
+	log.Printf("📊 Load run report written to %s\n", path)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// opusClockRate converts RTCP jitter (in RTP timestamp units) to time
+const opusClockRate = 48000
+
+// RTCPQuality is the uplink quality seen by the remote, taken from the
+// Receiver Reports it sends back for our audio stream
+type RTCPQuality struct {
+	ReceiverReports int64     `json:"receiver_reports"`
+	FractionLost    float64   `json:"fraction_lost"` // last reported, 0..1
+	TotalLost       uint32    `json:"total_lost"`
+	JitterMs        float64   `json:"jitter_ms"` // last reported
+	MaxJitterMs     float64   `json:"max_jitter_ms"`
+	RTTMs           float64   `json:"rtt_ms,omitempty"` // last computed, needs a prior Sender Report
+	MaxRTTMs        float64   `json:"max_rtt_ms,omitempty"`
+	LastReportAt    time.Time `json:"last_report_at,omitempty"`
+}
+
+// recordRTCP folds every reception report in packets into the call's quality
+func (d *CallIDDetails) recordRTCP(packets []rtcp.Packet) {
+	now := time.Now()
+	for _, packet := range packets {
+		rr, ok := packet.(*rtcp.ReceiverReport)
+		if !ok {
+			continue
+		}
+		for _, report := range rr.Reports {
+			d.recordReceptionReport(report, now)
+		}
+	}
+}
+
+func (d *CallIDDetails) recordReceptionReport(report rtcp.ReceptionReport, now time.Time) {
+	jitterMs := float64(report.Jitter) / opusClockRate * 1000
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q := &d.quality
+	q.ReceiverReports++
+	q.FractionLost = float64(report.FractionLost) / 256
+	q.TotalLost = report.TotalLost
+	q.JitterMs = jitterMs
+	q.MaxJitterMs = max(q.MaxJitterMs, jitterMs)
+	q.LastReportAt = now
+	if rtt, ok := rttFromReport(report, now); ok {
+		q.RTTMs = float64(rtt) / float64(time.Millisecond)
+		q.MaxRTTMs = max(q.MaxRTTMs, q.RTTMs)
+	}
+}
+
+func (d *CallIDDetails) Quality() RTCPQuality {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.quality
+}
+
+// rttFromReport computes RTT per RFC 3550 §6.4.1 as arrival - LSR - DLSR,
+// all in the middle 32 bits of an NTP timestamp (1/65536 s units)
+func rttFromReport(report rtcp.ReceptionReport, now time.Time) (time.Duration, bool) {
+	if report.LastSenderReport == 0 {
+		return 0, false
+	}
+	arrival := uint32(toNTP(now) >> 16)
+	rtt := arrival - report.LastSenderReport - report.Delay
+	if int32(rtt) < 0 {
+		return 0, false
+	}
+	return time.Duration(float64(rtt) / 65536 * float64(time.Second)), true
+}
+
+// toNTP converts t to a 64-bit NTP timestamp (seconds since 1900, 32.32 fixed point)
+func toNTP(t time.Time) uint64 {
+	s := float64(t.UnixNano())/1e9 + 2208988800
+	integerPart := uint32(s)
+	fractionalPart := uint32((s - float64(integerPart)) * 0xFFFFFFFF)
+	return uint64(integerPart)<<32 | uint64(fractionalPart)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// StatsSample is one --stats-sample-interval reading of a connected call:
+// cumulative transport counters from GetStats plus the latest RTCP quality
+type StatsSample struct {
+	At            time.Time `json:"at"`
+	BytesSent     uint64    `json:"bytes_sent"`
+	BytesReceived uint64    `json:"bytes_received"`
+	PacketsLost   uint32    `json:"packets_lost"`
+	FractionLost  float64   `json:"fraction_lost"`
+	JitterMs      float64   `json:"jitter_ms"`
+	RTTMs         float64   `json:"rtt_ms,omitempty"`
+}
+
+// startStatsSampling samples the call every --stats-sample-interval until
+// it ends, keeping the latest --stats-sample-max samples
+func startStatsSampling(details *CallIDDetails) {
+	interval := config.StatsSampleInterval
+	if interval <= 0 {
+		return
+	}
+	details.goTracked("stats_sampler", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				details.addSample(sampleStats(details), config.StatsSampleMax)
+			case <-details.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+func sampleStats(details *CallIDDetails) StatsSample {
+	quality := details.Quality()
+	sample := StatsSample{
+		At:           time.Now(),
+		PacketsLost:  quality.TotalLost,
+		FractionLost: quality.FractionLost,
+		JitterMs:     quality.JitterMs,
+		RTTMs:        quality.RTTMs,
+	}
+	for _, stats := range details.pc.GetStats() {
+		switch stats := stats.(type) {
+		case webrtc.TransportStats:
+			sample.BytesSent += stats.BytesSent
+			sample.BytesReceived += stats.BytesReceived
+		case webrtc.ICECandidatePairStats:
+			// Fall back to the STUN RTT, as collectFinalStats does
+			if stats.Nominated && sample.RTTMs == 0 {
+				sample.RTTMs = stats.CurrentRoundTripTime * 1000
+			}
+		}
+	}
+	return sample
+}
+
+// addSample appends sample, dropping the oldest beyond limit
+func (d *CallIDDetails) addSample(sample StatsSample, limit int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.samples = append(d.samples, sample)
+	if len(d.samples) > limit {
+		d.samples = append(d.samples[:0], d.samples[len(d.samples)-limit:]...)
+	}
+}
+
+func (d *CallIDDetails) Samples() []StatsSample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]StatsSample(nil), d.samples...)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+// signaledSDP applies the command-line rewrites to a local description
+// before it is sent: --strip-sdp-attrs, then --prefer-interface and
+// --ice-transport-pref tcp
+func signaledSDP(raw string) (string, error) {
+	out, err := stripSDPAttributes(raw, config.StripSDPAttrs)
+	if err != nil {
+		return "", err
+	}
+	if len(preferredAddrs) > 0 {
+		if out, err = preferCandidates(out, onPreferredInterface, false); err != nil {
+			return "", err
+		}
+	}
+	if config.ICETransportPref == ICETransportTCP {
+		if out, err = preferCandidates(out, isTCPCandidate, true); err != nil {
+			return "", err
+		}
+	}
+	return out, nil
+}
+
+// stripSDPAttributes removes every session- and media-level attribute whose
+// key is in names (e.g. "extmap", "rtcp-fb") and checks the result still parses
+func stripSDPAttributes(raw string, names []string) (string, error) {
+	if len(names) == 0 {
+		return raw, nil
+	}
+
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(raw); err != nil {
+		return "", fmt.Errorf("parsing SDP: %w", err)
+	}
+
+	strip := make(map[string]bool, len(names))
+	for _, name := range names {
+		strip[name] = true
+	}
+	filter := func(attributes []sdp.Attribute) []sdp.Attribute {
+		kept := attributes[:0]
+		for _, attribute := range attributes {
+			if !strip[attribute.Key] {
+				kept = append(kept, attribute)
+			}
+		}
+		return kept
+	}
+
+	parsed.Attributes = filter(parsed.Attributes)
+	for _, mediaDescription := range parsed.MediaDescriptions {
+		mediaDescription.Attributes = filter(mediaDescription.Attributes)
+	}
+
+	out, err := parsed.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("marshaling stripped SDP: %w", err)
+	}
+	var check sdp.SessionDescription
+	if err := check.Unmarshal(out); err != nil {
+		return "", fmt.Errorf("stripped SDP no longer parses: %w", err)
+	}
+	return string(out), nil
+}
+
+// ICECredentials are the local ICE username fragment and password of a call
+type ICECredentials struct {
+	Ufrag string `json:"ufrag"`
+	Pwd   string `json:"pwd"`
+}
+
+// iceCredentials reads the ICE credentials from an SDP, preferring the
+// session level and falling back to the first media section that has them
+func iceCredentials(raw string) (ICECredentials, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(raw); err != nil {
+		return ICECredentials{}, fmt.Errorf("parsing SDP: %w", err)
+	}
+
+	var creds ICECredentials
+	creds.Ufrag, _ = parsed.Attribute("ice-ufrag")
+	creds.Pwd, _ = parsed.Attribute("ice-pwd")
+	for _, mediaDescription := range parsed.MediaDescriptions {
+		if creds.Ufrag == "" {
+			creds.Ufrag, _ = mediaDescription.Attribute("ice-ufrag")
+		}
+		if creds.Pwd == "" {
+			creds.Pwd, _ = mediaDescription.Attribute("ice-pwd")
+		}
+	}
+	if creds.Ufrag == "" || creds.Pwd == "" {
+		return ICECredentials{}, fmt.Errorf("SDP has no ICE credentials")
+	}
+	return creds, nil
+}
+
+// SDPCandidate is one a=candidate line of an SDP, split into its fields
+type SDPCandidate struct {
+	Mid       string `json:"mid,omitempty"`
+	Component int    `json:"component"`
+	Protocol  string `json:"protocol"`
+	Priority  uint32 `json:"priority"`
+	Address   string `json:"address"`
+	Port      int    `json:"port"`
+	Type      string `json:"type"`
+	Candidate string `json:"candidate"`
+}
+
+// sdpCandidates lists the candidates of every media section of an SDP in
+// the order they appear
+func sdpCandidates(raw string) ([]SDPCandidate, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(raw); err != nil {
+		return nil, fmt.Errorf("parsing SDP: %w", err)
+	}
+
+	candidates := []SDPCandidate{}
+	for _, mediaDescription := range parsed.MediaDescriptions {
+		mid, _ := mediaDescription.Attribute("mid")
+		for _, attribute := range mediaDescription.Attributes {
+			if !attribute.IsICECandidate() {
+				continue
+			}
+			// foundation component protocol priority address port "typ" type ...
+			fields := strings.Fields(attribute.Value)
+			if len(fields) < 8 || fields[6] != "typ" {
+				return nil, fmt.Errorf("malformed candidate %q", attribute.Value)
+			}
+			component, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("candidate component %q: %w", fields[1], err)
+			}
+			priority, err := strconv.ParseUint(fields[3], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("candidate priority %q: %w", fields[3], err)
+			}
+			port, err := strconv.Atoi(fields[5])
+			if err != nil {
+				return nil, fmt.Errorf("candidate port %q: %w", fields[5], err)
+			}
+			candidates = append(candidates, SDPCandidate{
+				Mid:       mid,
+				Component: component,
+				Protocol:  strings.ToLower(fields[2]),
+				Priority:  uint32(priority),
+				Address:   fields[4],
+				Port:      port,
+				Type:      fields[7],
+				Candidate: attribute.Key + ":" + attribute.Value,
+			})
+		}
+	}
+	return candidates, nil
+}
+
+// preferredAddrs are the addresses of --prefer-interface, set at startup
+var preferredAddrs map[string]bool
+
+func interfaceAddrs(name string) (map[string]bool, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			found[ipNet.IP.String()] = true
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("interface %s has no addresses", name)
+	}
+	return found, nil
+}
+
+// onPreferredInterface matches candidates on a --prefer-interface address
+func onPreferredInterface(fields []string) bool {
+	return preferredAddrs[fields[4]]
+}
+
+func isTCPCandidate(fields []string) bool {
+	return strings.EqualFold(fields[2], "tcp")
+}
+
+// preferCandidates moves the candidates preferred matches (given the fields
+// of the candidate line) to the front of each media section and halves the
+// local preference of the rest, so the remote ranks their pairs first among
+// candidates of the same type. With outrank the rest are also capped below
+// the lowest preferred priority, for preferences that cut across candidate
+// types, like TCP (which pion gives a lower type preference) over UDP.
+func preferCandidates(raw string, preferred func(fields []string) bool, outrank bool) (string, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(raw); err != nil {
+		return "", fmt.Errorf("parsing SDP: %w", err)
+	}
+
+	for _, mediaDescription := range parsed.MediaDescriptions {
+		var first, others []sdp.Attribute
+		var rest [][]string
+		lowest := uint64(math.MaxUint32)
+		for _, attribute := range mediaDescription.Attributes {
+			if !attribute.IsICECandidate() {
+				continue
+			}
+			fields := strings.Fields(attribute.Value)
+			if len(fields) < 5 {
+				others = append(others, attribute)
+				continue
+			}
+			priority, err := strconv.ParseUint(fields[3], 10, 32)
+			if err != nil {
+				return "", fmt.Errorf("candidate priority %q: %w", fields[3], err)
+			}
+			if preferred(fields) {
+				first = append(first, attribute)
+				lowest = min(lowest, priority)
+				continue
+			}
+			rest = append(rest, fields)
+		}
+		if len(first) == 0 {
+			continue
+		}
+		for _, fields := range rest {
+			priority, _ := strconv.ParseUint(fields[3], 10, 32)
+			// priority = type preference<<24 | local preference<<8 | (256 - component)
+			localPreference := priority >> 8 & 0xFFFF
+			priority = priority&^(0xFFFF<<8) | (localPreference/2)<<8
+			if outrank && priority >= lowest&^0xFF {
+				// One local preference step below, keeping the component
+				priority = lowest&^0xFF - 1<<8 | priority&0xFF
+			}
+			fields[3] = strconv.FormatUint(priority, 10)
+			others = append(others, sdp.Attribute{Key: "candidate", Value: strings.Join(fields, " ")})
+		}
+
+		candidates := append(first, others...)
+		attributes := make([]sdp.Attribute, 0, len(mediaDescription.Attributes))
+		for _, attribute := range mediaDescription.Attributes {
+			if !attribute.IsICECandidate() {
+				attributes = append(attributes, attribute)
+				continue
+			}
+			// All candidates go where the first one was
+			attributes = append(attributes, candidates...)
+			candidates = nil
+		}
+		mediaDescription.Attributes = attributes
+	}
+
+	out, err := parsed.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("marshaling SDP: %w", err)
+	}
+	return string(out), nil
+}
+
+// validateAnswer checks an answer against the offer it answers: one media
+// section per offered section with the same media type, only offered codecs,
+// and a well-formed DTLS fingerprint
+func validateAnswer(offerSDP, answerSDP string) error {
+	var offer, answer sdp.SessionDescription
+	if err := offer.UnmarshalString(offerSDP); err != nil {
+		return fmt.Errorf("parsing offer: %w", err)
+	}
+	if err := answer.UnmarshalString(answerSDP); err != nil {
+		return fmt.Errorf("answer is not valid SDP: %w", err)
+	}
+
+	if len(answer.MediaDescriptions) != len(offer.MediaDescriptions) {
+		return fmt.Errorf("answer has %d media sections, offer has %d", len(answer.MediaDescriptions), len(offer.MediaDescriptions))
+	}
+
+	sessionFingerprint, _ := answer.Attribute("fingerprint")
+	for i, answered := range answer.MediaDescriptions {
+		offered := offer.MediaDescriptions[i]
+		if answered.MediaName.Media != offered.MediaName.Media {
+			return fmt.Errorf("media section %d is %s, offer has %s", i, answered.MediaName.Media, offered.MediaName.Media)
+		}
+		// Port 0 rejects the section, so there is nothing to negotiate
+		if answered.MediaName.Port.Value == 0 {
+			continue
+		}
+
+		offeredCodecs := rtpmapCodecs(offered)
+		for _, format := range answered.MediaName.Formats {
+			codec, ok := rtpmapCodecs(answered)[format]
+			if !ok {
+				continue // static payload type without rtpmap
+			}
+			if !containsCodec(offeredCodecs, codec) {
+				return fmt.Errorf("media section %d answers codec %s, which was not offered", i, codec)
+			}
+		}
+
+		fingerprint, ok := answered.Attribute("fingerprint")
+		if !ok {
+			fingerprint = sessionFingerprint
+		}
+		if err := checkFingerprint(fingerprint); err != nil {
+			return fmt.Errorf("media section %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// rtpmapCodecs maps payload type to "name/clock rate", lower-cased
+func rtpmapCodecs(media *sdp.MediaDescription) map[string]string {
+	codecs := map[string]string{}
+	for _, attribute := range media.Attributes {
+		if attribute.Key != "rtpmap" {
+			continue
+		}
+		payloadType, codec, ok := strings.Cut(attribute.Value, " ")
+		if !ok {
+			continue
+		}
+		// Drop the channel count: opus/48000/2 and opus/48000 are the same codec
+		parts := strings.SplitN(codec, "/", 3)
+		codecs[payloadType] = strings.ToLower(strings.Join(parts[:min(len(parts), 2)], "/"))
+	}
+	return codecs
+}
+
+func containsCodec(codecs map[string]string, codec string) bool {
+	for _, offered := range codecs {
+		if offered == codec {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFingerprint(fingerprint string) error {
+	if fingerprint == "" {
+		return fmt.Errorf("answer has no DTLS fingerprint")
+	}
+	algorithm, value, ok := strings.Cut(fingerprint, " ")
+	if !ok || algorithm == "" {
+		return fmt.Errorf("malformed fingerprint %q", fingerprint)
+	}
+	for _, octet := range strings.Split(value, ":") {
+		if _, err := strconv.ParseUint(octet, 16, 8); err != nil || len(octet) != 2 {
+			return fmt.Errorf("malformed fingerprint %q", fingerprint)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// callsCtx parents every call's context. main replaces it with a context
+// that SIGINT cancels, so a fast shutdown stops all calls' media and
+// signaling at once.
+var callsCtx = context.Background()
+
+// draining is set once SIGTERM starts a graceful shutdown; new offers and
+// answers are refused with 503 while existing calls finish
+var draining atomic.Bool
+
+const errShuttingDown = "Server is shutting down"
+
+// callbackFlushTimeout bounds how long shutdown waits for pending
+// callbacks, chiefly each call's terminate callback, to be delivered
+const callbackFlushTimeout = 5 * time.Second
+
+// drainCalls waits up to grace for the registered calls to end on their
+// own, giving up early if interrupted (a SIGINT during the drain)
+func drainCalls(interrupted context.Context, grace time.Duration) {
+	draining.Store(true)
+	log.Printf("🔄 Draining %d calls for up to %s\n", registeredCalls(), grace)
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	poll := time.NewTicker(200 * time.Millisecond)
+	defer poll.Stop()
+	for registeredCalls() > 0 {
+		select {
+		case <-poll.C:
+		case <-deadline.C:
+			log.Printf("🔄 Shutdown grace elapsed with %d calls left\n", registeredCalls())
+			return
+		case <-interrupted.Done():
+			log.Println("🔄 Interrupted while draining")
+			return
+		}
+	}
+	log.Println("✅ All calls drained")
+}
+
+// flushCallbacks stops new callbacks and waits up to timeout for the
+// pending ones to finish
+func flushCallbacks(timeout time.Duration) {
+	callbacksMu.Lock()
+	callbacksFlushed = true
+	callbacksMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		pendingCallbacks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("✅ Pending callbacks delivered")
+	case <-time.After(timeout):
+		log.Printf("⚠️ Gave up on pending callbacks after %s\n", timeout)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// signalingLatency is a flag.Value for --signaling-latency: a single
+// duration applied to both the offer response and the offer callback, or
+// response,callback to make them asymmetric (e.g. 50ms,400ms). Failed
+// offers are answered without delay.
+type signalingLatency struct {
+	response, callback time.Duration
+}
+
+func (l *signalingLatency) String() string {
+	if l.response == l.callback {
+		return l.response.String()
+	}
+	return fmt.Sprintf("%s,%s", l.response, l.callback)
+}
+
+func (l *signalingLatency) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) > 2 {
+		return fmt.Errorf("signaling latency %q: want one duration or response,callback", value)
+	}
+	durations := make([]time.Duration, len(parts))
+	for i, part := range parts {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("signaling latency %q: %w", value, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("signaling latency %q: durations must not be negative", value)
+		}
+		durations[i] = d
+	}
+	*l = signalingLatency{response: durations[0], callback: durations[len(durations)-1]}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// simulcastRIDs are the layers an offer with simulcast: true advertises,
+// top layer first. Every layer carries the same audio; a layer with
+// every N sends one frame in N.
+var simulcastRIDs = []struct {
+	rid   string
+	every int
+}{
+	{"h", 1},
+	{"m", 2},
+	{"l", 4},
+}
+
+// simulcastLayer is one lower encoding of a simulcast sender
+type simulcastLayer struct {
+	track *webrtc.TrackLocalStaticSample
+	every int
+}
+
+// simulcastTrackOptions tags the base track with the top layer's RID,
+// which pion needs before further encodings can be added to its sender
+func simulcastTrackOptions(simulcast bool) []func(*webrtc.TrackLocalStaticRTP) {
+	if !simulcast {
+		return nil
+	}
+	return []func(*webrtc.TrackLocalStaticRTP){webrtc.WithRTPStreamID(simulcastRIDs[0].rid)}
+}
+
+// addSimulcastLayers adds the lower layers to sender as extra encodings,
+// so the offer carries a=rid and a=simulcast:send lines
+func addSimulcastLayers(codec webrtc.RTPCodecCapability, sender *webrtc.RTPSender) ([]simulcastLayer, error) {
+	var layers []simulcastLayer
+	for _, layer := range simulcastRIDs[1:] {
+		track, err := webrtc.NewTrackLocalStaticSample(codec, "audio", "pion", webrtc.WithRTPStreamID(layer.rid))
+		if err != nil {
+			return nil, err
+		}
+		if err := sender.AddEncoding(track); err != nil {
+			return nil, err
+		}
+		layers = append(layers, simulcastLayer{track: track, every: layer.every})
+	}
+	return layers, nil
+}
+
+// writeSimulcastLayers writes frame n of the source to the lower layers
+// that send it, each frame covering the ones the layer skips so its RTP
+// clock keeps pace with the top layer
+func writeSimulcastLayers(layers []simulcastLayer, n int, sample media.Sample) error {
+	for _, layer := range layers {
+		if n%layer.every != 0 {
+			continue
+		}
+		paced := sample
+		paced.Duration *= time.Duration(layer.every)
+		if err := layer.track.WriteSample(paced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simulcastStreams maps the SSRC of every simulcast encoding we send to
+// the MID and RID stamped on its packets. pion negotiates the header
+// extensions but does not write them, and receivers need them to tell
+// the layers apart.
+var simulcastStreams sync.Map
+
+type simulcastStream struct {
+	mid, rid string
+}
+
+// registerSimulcastStreams records sender's encodings for the header
+// extension interceptor until ctx is done. The transceiver's MID is only
+// known once the local description is set.
+func registerSimulcastStreams(details *CallIDDetails, sender *webrtc.RTPSender) {
+	var mid string
+	for _, transceiver := range details.pc.GetTransceivers() {
+		if transceiver.Sender() == sender {
+			mid = transceiver.Mid()
+		}
+	}
+	encodings := sender.GetParameters().Encodings
+	for _, encoding := range encodings {
+		simulcastStreams.Store(uint32(encoding.SSRC), simulcastStream{mid: mid, rid: encoding.RID})
+	}
+	details.goTracked("simulcast_streams", func() {
+		<-details.ctx.Done()
+		for _, encoding := range encodings {
+			simulcastStreams.Delete(uint32(encoding.SSRC))
+		}
+	})
+}
+
+// simulcastHeaderInterceptor writes the MID and RID header extensions on
+// packets of registered simulcast encodings
+type simulcastHeaderInterceptor struct {
+	interceptor.NoOp
+}
+
+type simulcastHeaderFactory struct{}
+
+func (simulcastHeaderFactory) NewInterceptor(string) (interceptor.Interceptor, error) {
+	return &simulcastHeaderInterceptor{}, nil
+}
+
+func (i *simulcastHeaderInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	val, ok := simulcastStreams.Load(info.SSRC)
+	if !ok {
+		return writer
+	}
+	stream := val.(simulcastStream)
+
+	var midID, ridID uint8
+	for _, ext := range info.RTPHeaderExtensions {
+		switch ext.URI {
+		case sdp.SDESMidURI:
+			midID = uint8(ext.ID)
+		case sdp.SDESRTPStreamIDURI:
+			ridID = uint8(ext.ID)
+		}
+	}
+	if midID == 0 || ridID == 0 {
+		return writer
+	}
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if err := header.SetExtension(midID, []byte(stream.mid)); err != nil {
+			return 0, err
+		}
+		if err := header.SetExtension(ridID, []byte(stream.rid)); err != nil {
+			return 0, err
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
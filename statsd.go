@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsDSink mirrors the lifecycle metrics to a StatsD server over UDP.
+// Sends are fire-and-forget: a missing or slow server never blocks calls.
+type StatsDSink struct {
+	conn   net.Conn
+	prefix string
+}
+
+func newStatsDSink(addr, prefix string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsDSink{conn: conn, prefix: prefix}, nil
+}
+
+func (s *StatsDSink) send(name, value, kind string) {
+	// Errors are dropped: UDP gives no delivery guarantee anyway
+	fmt.Fprintf(s.conn, "%s%s:%s|%s", s.prefix, name, value, kind)
+}
+
+func (s *StatsDSink) count(name string) {
+	s.send(name, "1", "c")
+}
+
+func (s *StatsDSink) timing(name string, d time.Duration) {
+	s.send(name, fmt.Sprintf("%d", d.Milliseconds()), "ms")
+}
+
+func (s *StatsDSink) gauge(name string, value int) {
+	s.send(name, fmt.Sprintf("%d", value), "g")
+}
+
+// statsdDirection names a call direction as a metric path segment
+func statsdDirection(direction string) string {
+	if direction == DirectionBusinessInitiated {
+		return "answer"
+	}
+	return "offer"
+}
+
+// handle is the EventBus subscriber
+func (s *StatsDSink) handle(event LifecycleEvent) {
+	direction := statsdDirection(event.Direction)
+	switch event.Type {
+	case EventCreated:
+		s.count("calls.created." + direction)
+		s.gauge("calls.active", registeredCalls())
+	case EventAccepted:
+		s.count("calls.accepted")
+		s.timing("calls.accept_latency", event.At.Sub(event.Details.createdAt))
+	case EventConnected:
+		s.count("calls.connected." + direction)
+		s.timing("calls.connect_latency", event.At.Sub(event.Details.createdAt))
+	case EventConnectionState:
+		s.count("calls.connection_state." + event.ConnectionState.String())
+	case EventFailed:
+		s.count("calls.failed." + direction)
+	case EventTerminated:
+		s.count("calls.terminated." + event.Record.Reason)
+		s.timing("calls.duration", time.Duration(event.Record.DurationMs)*time.Millisecond)
+		s.gauge("calls.active", registeredCalls())
+	}
+}
+
+// registerStatsDSink subscribes a StatsD sink when --statsd-addr is set
+func registerStatsDSink() error {
+	if config.StatsDAddr == "" {
+		return nil
+	}
+	sink, err := newStatsDSink(config.StatsDAddr, config.StatsDPrefix)
+	if err != nil {
+		return err
+	}
+	events.Subscribe(sink.handle)
+	log.Printf("📊 Sending metrics to StatsD at %s\n", config.StatsDAddr)
+	return nil
+}
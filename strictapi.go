@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// DefaultWebhookObject is the envelope object emitted unless --webhook-object
+// says otherwise
+const DefaultWebhookObject = "random_business_account"
+
+// validateStrictAPI checks, with --strict-api, that the object and default
+// messaging_product we emit are values the SUT accepts. A SUT typically
+// drops webhooks with an unknown object or product without an error, so a
+// typo here would otherwise only show up as a load run where nothing
+// arrived.
+func (c Config) validateStrictAPI() error {
+	if !c.StrictAPI {
+		return nil
+	}
+	if err := checkAllowed("webhook-object", c.WebhookObject, c.AllowedObjects, "allowed-objects"); err != nil {
+		return err
+	}
+	return checkAllowed("messaging-product", c.MessagingProduct, c.AllowedProducts, "allowed-products")
+}
+
+func checkAllowed(flagName, value string, allowed []string, allowedFlag string) error {
+	if slices.Contains(allowed, value) {
+		return nil
+	}
+	err := fmt.Sprintf("%s %q is not in --%s (%s)", flagName, value, allowedFlag, strings.Join(allowed, ", "))
+	if suggestion := closestMatch(value, allowed); suggestion != "" {
+		err += fmt.Sprintf("; did you mean %q?", suggestion)
+	}
+	return fmt.Errorf("%s", err)
+}
+
+// closestMatch is the allowed value within two edits of value, ignoring
+// case and surrounding space, or "" if none is that close
+func closestMatch(value string, allowed []string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	best, bestDistance := "", 3
+	for _, candidate := range allowed {
+		if d := editDistance(value, strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func logEmittedValues() {
+	mode := "not checked"
+	if config.StrictAPI {
+		mode = "checked by --strict-api"
+	}
+	log.Printf("📩 Webhooks use object %q and messaging_product %q (%s)\n", config.WebhookObject, config.MessagingProduct, mode)
+}
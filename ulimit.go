@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// What --check-ulimit does when RLIMIT_NOFILE looks too low for --max-calls
+const (
+	UlimitCheckOff  = "off"  // skip the check
+	UlimitCheckWarn = "warn" // log a warning and start anyway
+	UlimitCheckFail = "fail" // refuse to start
+)
+
+func validateUlimitCheck(mode string) error {
+	switch mode {
+	case UlimitCheckOff, UlimitCheckWarn, UlimitCheckFail:
+		return nil
+	}
+	return fmt.Errorf("invalid ulimit check %q (want %s, %s or %s)", mode, UlimitCheckOff, UlimitCheckWarn, UlimitCheckFail)
+}
+
+// Estimated descriptors per call: the ICE UDP sockets (one per local
+// address, plus the mDNS sockets unless --host-only) and a connection for
+// signaling or callbacks. Measured on a host with one IPv4 and one IPv6
+// address; more interfaces cost more.
+const (
+	fdsPerCall         = 8
+	fdsPerCallHostOnly = 4
+
+	// fdReserve covers listeners, log files and idle HTTP connections
+	fdReserve = 100
+)
+
+// checkUlimit compares the open file limit against what --max-calls
+// concurrent calls need. Go raises the soft limit to the hard limit at
+// startup, so the soft limit read here is what calls can actually use.
+func checkUlimit(mode string, maxCalls int, hostOnly bool) error {
+	if mode == UlimitCheckOff {
+		return nil
+	}
+	if maxCalls <= 0 {
+		log.Printf("⚠️ Skipping --check-ulimit: --max-calls is unlimited\n")
+		return nil
+	}
+
+	soft, hard, err := openFileLimit()
+	if err != nil {
+		return fmt.Errorf("reading the open file limit: %w", err)
+	}
+	perCall := fdsPerCall
+	if hostOnly {
+		perCall = fdsPerCallHostOnly
+	}
+	needed := uint64(maxCalls*perCall + fdReserve)
+	if soft >= needed {
+		log.Printf("✅ Open file limit %d covers %d calls (about %d descriptors)\n", soft, maxCalls, needed)
+		return nil
+	}
+
+	err = fmt.Errorf("open file limit %d (hard %d) is below the ~%d descriptors %d calls need; raise ulimit -n or lower --max-calls", soft, hard, needed, maxCalls)
+	if mode == UlimitCheckFail {
+		return err
+	}
+	log.Printf("⚠️ %v\n", err)
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func openFileLimit() (soft, hard uint64, err error) {
+	return 0, 0, errors.New("not supported on this platform")
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import "golang.org/x/sys/unix"
+
+func openFileLimit() (soft, hard uint64, err error) {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, err
+	}
+	return uint64(limit.Cur), uint64(limit.Max), nil
+}